@@ -0,0 +1,353 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mdhender/worldgen/pkg/auth"
+	"github.com/mdhender/worldgen/pkg/gen"
+	"github.com/mdhender/worldgen/pkg/way"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The JSON API lives under /api/v1. Every error from the API is returned as
+//
+//	{"error": {"status": 404, "code": "not_found", "message": "..."}}
+//
+// so that scripts don't have to parse the plain text errors of the web pages.
+
+// apiError is the body of an error response.
+type apiError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiWorld describes a cached world.
+type apiWorld struct {
+	Code string `json:"code"`
+	gen.Metadata
+	Links map[string]string `json:"links"`
+}
+
+func newAPIWorld(code string, md gen.Metadata) apiWorld {
+	self := "/api/v1/worlds/" + code
+	return apiWorld{
+		Code:     code,
+		Metadata: md,
+		Links: map[string]string{
+			"self":      self,
+			"render":    self + "/render",
+			"stats":     self + "/stats",
+			"heightmap": self + "/heightmap",
+		},
+	}
+}
+
+// writeJSON writes the value as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+// writeAPIError writes a structured error response.
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	body := struct {
+		Error apiError `json:"error"`
+	}{apiError{
+		Status:  status,
+		Code:    strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"),
+		Message: message,
+	}}
+	data, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+// apiErrors lets the API share the handlers of the web pages. It catches
+// the plain text errors that they write with http.Error and rewrites them
+// as structured errors. Successful responses and errors that are already
+// JSON are passed through untouched.
+func apiErrors(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ew := &errorWriter{ResponseWriter: w}
+		h.ServeHTTP(ew, r)
+		if ew.status != 0 {
+			w.Header().Del("X-Content-Type-Options")
+			writeAPIError(w, ew.status, strings.TrimSpace(ew.body.String()))
+		}
+	}
+}
+
+// errorWriter holds back error responses so that apiErrors can rewrite them.
+type errorWriter struct {
+	http.ResponseWriter
+	status int // set when the handler writes an error
+	body   bytes.Buffer
+}
+
+func (ew *errorWriter) WriteHeader(status int) {
+	// errors from the API's own handlers are already structured
+	if status >= 400 && ew.Header().Get("Content-Type") != "application/json" {
+		ew.status = status
+		return
+	}
+	ew.ResponseWriter.WriteHeader(status)
+}
+
+func (ew *errorWriter) Write(p []byte) (int, error) {
+	if ew.status != 0 {
+		return ew.body.Write(p)
+	}
+	return ew.ResponseWriter.Write(p)
+}
+
+// Flush lets streaming handlers, like the job events, work through apiErrors.
+func (ew *errorWriter) Flush() {
+	if ew.status != 0 {
+		return
+	}
+	if flusher, ok := ew.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (ew *errorWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+// apiNotFoundHandler answers requests for unknown parts of the API.
+func apiNotFoundHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("%s %s: no such endpoint", r.Method, r.URL.Path))
+	}
+}
+
+// apiListWorldsHandler returns the worlds in the cache, sorted by code.
+func apiListWorldsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		codes, err := cachedMaps()
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		list := []apiWorld{}
+		for _, code := range codes {
+			md, err := loadMetadata(code)
+			if err != nil {
+				// one bad file shouldn't hide the rest of the cache
+				log.Printf("%s %s: %s: %v\n", r.Method, r.URL, code, err)
+				continue
+			}
+			list = append(list, newAPIWorld(code, md))
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Worlds []apiWorld `json:"worlds"`
+		}{list})
+	}
+}
+
+// apiGetWorldHandler returns the description of one world.
+func apiGetWorldHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code := way.Param(r.Context(), "name")
+		if !isMapName(code) {
+			writeAPIError(w, http.StatusBadRequest, "invalid world code")
+			return
+		}
+		md, err := loadMetadata(code)
+		if errors.Is(err, os.ErrNotExist) {
+			writeAPIError(w, http.StatusNotFound, fmt.Sprintf("world %q not found", code))
+			return
+		} else if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, newAPIWorld(code, md))
+	}
+}
+
+// apiCreateWorldHandler creates a world from a JSON request like
+//
+//	{"seed": "c0ffeecafe", "add_faults": 0}
+//
+// The seed is hexadecimal, like the web form. If the world is already in
+// the cache, it returns 200 and the world. Otherwise it queues a job to
+// create the world and returns 202 and the job, without waiting for it.
+// Creating a world needs a token with the generate role. Older clients
+// may send the token as "secret" instead of in the Authorization header.
+func apiCreateWorldHandler(a *authenticator, lim *limits, ws *worlds, jobs *jobQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Seed      string `json:"seed"`
+			AddFaults int    `json:"add_faults"`
+			Secret    string `json:"secret"` // deprecated
+		}
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&input); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+			return
+		}
+		seed, err := strconv.ParseUint(input.Seed, 16, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("%q: must be a hexadecimal number", "seed"))
+			return
+		} else if err = ws.checkFaults(input.AddFaults); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		code := ws.mapName(seed, input.AddFaults)
+		if md, err := loadMetadata(code); err == nil {
+			writeJSON(w, http.StatusOK, newAPIWorld(code, md))
+			return
+		}
+		u, err := a.user(r)
+		if input.Secret != "" && a.enabled() && r.Header.Get("Authorization") == "" {
+			u, err = a.authenticate(r, input.Secret)
+		}
+		if !a.check(w, u, err, auth.RoleGenerate) || !lim.allowed(w, r, lim.generate) {
+			return
+		}
+		j, err := jobs.submit(seed, input.AddFaults, false)
+		if errors.Is(err, errQueueFull) {
+			w.Header().Set("Retry-After", "30")
+			writeAPIError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		} else if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		status, _ := j.snapshot()
+		w.Header().Set("Location", "/api/v1/jobs/"+status.ID)
+		writeJSON(w, http.StatusAccepted, newAPIJob(status))
+	}
+}
+
+// apiJob describes a job that is creating a world.
+type apiJob struct {
+	jobStatus
+	Links map[string]string `json:"links"`
+}
+
+func newAPIJob(status jobStatus) apiJob {
+	self := "/api/v1/jobs/" + status.ID
+	return apiJob{
+		jobStatus: status,
+		Links: map[string]string{
+			"self":   self,
+			"events": self + "/events",
+			"world":  "/api/v1/worlds/" + status.Code,
+		},
+	}
+}
+
+// apiGetJobHandler returns the status of a job, for clients that poll.
+func apiGetJobHandler(jobs *jobQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := way.Param(r.Context(), "id")
+		j := jobs.get(id)
+		if j == nil {
+			writeAPIError(w, http.StatusNotFound, fmt.Sprintf("job %q not found", id))
+			return
+		}
+		status, _ := j.snapshot()
+		writeJSON(w, http.StatusOK, newAPIJob(status))
+	}
+}
+
+// apiJobEventsHandler streams the status of a job as Server-Sent Events.
+// A "progress" event is sent whenever the status changes, and a "done"
+// or "failed" event when the job finishes, after which the stream ends.
+func apiJobEventsHandler(jobs *jobQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := way.Param(r.Context(), "id")
+		j := jobs.get(id)
+		if j == nil {
+			writeAPIError(w, http.StatusNotFound, fmt.Sprintf("job %q not found", id))
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeAPIError(w, http.StatusInternalServerError, "streaming is not supported")
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		for {
+			status, changed := j.snapshot()
+			event := "progress"
+			if status.finished() {
+				event = string(status.State)
+			}
+			data, err := json.Marshal(newAPIJob(status))
+			if err != nil {
+				return
+			}
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+				return
+			}
+			flusher.Flush()
+			if status.finished() {
+				return
+			}
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// loadMetadata returns the metadata of a cached map without loading the whole map.
+// It falls back to loading the older JSON files if there's no binary file.
+func loadMetadata(name string) (gen.Metadata, error) {
+	fp, err := os.Open(name + ".wgm")
+	if errors.Is(err, os.ErrNotExist) {
+		m, err := loadMap(name)
+		if err != nil {
+			return gen.Metadata{}, err
+		}
+		return m.Metadata(), nil
+	} else if err != nil {
+		return gen.Metadata{}, err
+	}
+	defer fp.Close()
+	return gen.ReadBinaryMetadata(fp)
+}
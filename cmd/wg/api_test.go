@@ -0,0 +1,84 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		message string // of the structured error, empty if the response is passed through
+		body    string // of a response that is passed through
+	}{
+		{"plain error", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "invalid map name", http.StatusBadRequest)
+		}, http.StatusBadRequest, "invalid map name", ""},
+		{"empty error", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}, http.StatusNotFound, "Not Found", ""},
+		{"json error", func(w http.ResponseWriter, r *http.Request) {
+			writeAPIError(w, http.StatusConflict, "already exists")
+		}, http.StatusConflict, "already exists", ""},
+		{"success", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("ok"))
+		}, http.StatusOK, "", "ok"},
+	} {
+		rec := httptest.NewRecorder()
+		apiErrors(tc.handler).ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/worlds", nil))
+		if rec.Code != tc.status {
+			t.Errorf("%s: status: want %d, got %d", tc.name, tc.status, rec.Code)
+		}
+		if tc.message == "" {
+			if rec.Body.String() != tc.body {
+				t.Errorf("%s: body: want %q, got %q", tc.name, tc.body, rec.Body.String())
+			}
+			continue
+		}
+		var body struct {
+			Error apiError `json:"error"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s: %v: %q", tc.name, err, rec.Body.String())
+		} else if body.Error.Status != tc.status || body.Error.Message != tc.message {
+			t.Errorf("%s: want %d %q, got %d %q", tc.name, tc.status, tc.message, body.Error.Status, body.Error.Message)
+		}
+	}
+}
+
+// TestAPIErrorsFlush checks that streaming handlers can flush through apiErrors.
+func TestAPIErrorsFlush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	apiErrors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("want a flusher")
+		}
+		_, _ = w.Write([]byte("data: {}\n\n"))
+		flusher.Flush()
+	})).ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/jobs/1/events", nil))
+	if !rec.Flushed {
+		t.Error("want the response flushed")
+	}
+}
@@ -0,0 +1,275 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mdhender/worldgen/pkg/auth"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sessionCookie holds the session id of a user logged in to the web pages.
+const sessionCookie = "wg_session"
+
+// legacyUser is the user whose token is the WMG_SECRET of older servers.
+const legacyUser = "admin"
+
+// authenticator decides who a request comes from and what they may do.
+// API clients send their token in an "Authorization: Bearer" header.
+// Browsers log in with the token once and then send a session cookie.
+type authenticator struct {
+	users    *auth.Users // nil if anyone can do anything
+	sessions *auth.Sessions
+	hashing  *rateLimiter // tokens hashed for each address
+}
+
+// tooManyTokens is returned when an address has sent too many tokens
+// that had to be hashed.
+type tooManyTokens struct {
+	wait time.Duration
+}
+
+func (e *tooManyTokens) Error() string {
+	return fmt.Sprintf("too many tokens, try again in %d seconds", retryAfter(e.wait))
+}
+
+// newAuthenticator loads the users file. Without one, WMG_SECRET is the
+// token of a single admin user and anyone may view cached maps, like older
+// servers. Without either, there are no restrictions at all.
+func newAuthenticator(usersFile, secret string) (*authenticator, error) {
+	// hashing a token is slow on purpose, so each address can only have
+	// a few hashed a minute. tokens are only hashed the first time they are
+	// used, so this limits guessing without getting in the way of users.
+	a := &authenticator{sessions: auth.NewSessions(7 * 24 * time.Hour), hashing: newRateLimiter(0.2, 10)}
+	if usersFile != "" {
+		users, err := auth.LoadUsers(usersFile)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("auth: %s: %d users\n", usersFile, users.Len())
+		a.users = users
+	} else if secret != "" {
+		hash, err := auth.Hash(secret)
+		if err != nil {
+			return nil, err
+		}
+		users, err := auth.NewUsers([]auth.Role{auth.RoleRender}, &auth.User{
+			Name:      legacyUser,
+			TokenHash: hash,
+			Roles:     []auth.Role{auth.RoleRender, auth.RoleGenerate},
+		})
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("auth: WMG_SECRET is the token for %q\n", legacyUser)
+		a.users = users
+	} else {
+		log.Printf("auth: no users file or WMG_SECRET, anyone can create maps\n")
+	}
+	return a, nil
+}
+
+// enabled returns true if some requests need a token.
+func (a *authenticator) enabled() bool {
+	return a.users != nil
+}
+
+// authenticate returns the user for a token sent with the request.
+// Tokens that haven't been seen before are charged to the client's address,
+// so that nobody can keep the server busy hashing bad tokens.
+func (a *authenticator) authenticate(r *http.Request, token string) (*auth.User, error) {
+	if a.users == nil {
+		return nil, auth.ErrInvalidToken
+	}
+	if !strings.Contains(token, ".") {
+		// the secret of older servers doesn't have the user's name
+		token = legacyUser + "." + token
+	}
+	if u := a.users.Verified(token); u != nil {
+		return u, nil
+	} else if ok, wait := a.hashing.allow(clientAddress(r)); !ok {
+		return nil, &tooManyTokens{wait: wait}
+	}
+	return a.users.Authenticate(token)
+}
+
+// user returns the user that sent the request. Requests without a token or
+// session are anonymous. It returns an error if the token is not valid.
+func (a *authenticator) user(r *http.Request) (*auth.User, error) {
+	if a.users == nil {
+		return &auth.User{Roles: []auth.Role{auth.RoleRender, auth.RoleGenerate}}, nil
+	}
+	if h := r.Header.Get("Authorization"); h != "" {
+		token, ok := strings.CutPrefix(h, "Bearer ")
+		if !ok {
+			return nil, errors.New("authorization must be a bearer token")
+		}
+		return a.authenticate(r, strings.TrimSpace(token))
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if u := a.sessions.Get(c.Value); u != nil {
+			return u, nil
+		}
+	}
+	return a.users.Anonymous(), nil
+}
+
+// check writes an error and returns false if the user doesn't have the role.
+// Anonymous users are asked for a token, everyone else is refused.
+func (a *authenticator) check(w http.ResponseWriter, u *auth.User, err error, role auth.Role) bool {
+	var tooMany *tooManyTokens
+	if errors.As(err, &tooMany) {
+		tooManyRequests(w, tooMany.wait)
+		return false
+	} else if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	} else if u.Can(role) {
+		return true
+	} else if u.Name == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "a token with the "+string(role)+" role is needed", http.StatusUnauthorized)
+		return false
+	}
+	http.Error(w, u.Name+" does not have the "+string(role)+" role", http.StatusForbidden)
+	return false
+}
+
+// allowed writes an error and returns false if the request may not do something.
+func (a *authenticator) allowed(w http.ResponseWriter, r *http.Request, role auth.Role) bool {
+	u, err := a.user(r)
+	return a.check(w, u, err, role)
+}
+
+// require only calls h if the request may do something.
+func (a *authenticator) require(role auth.Role, h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.allowed(w, r, role) {
+			h.ServeHTTP(w, r)
+		}
+	}
+}
+
+// page is like require for web pages, but sends anonymous users to the login page.
+func (a *authenticator) page(role auth.Role, h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, err := a.user(r)
+		if err == nil && u.Name == "" && !u.Can(role) {
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+		if a.check(w, u, err, role) {
+			h.ServeHTTP(w, r)
+		}
+	}
+}
+
+// loginHandler shows the login form and starts a session when a valid token is posted.
+func (a *authenticator) loginHandler(root string) http.HandlerFunc {
+	root = filepath.Clean(root)
+	rr := Renderer{}
+	for _, tmpl := range []string{"layout", "login"} {
+		rr.files = append(rr.files, filepath.Join(root, tmpl+".gohtml"))
+	}
+	log.Printf("login: %v\n", rr.files)
+
+	type Data struct {
+		Next    string
+		Message string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		next := localPage(r.FormValue("next"))
+		if r.Method != http.MethodPost {
+			rr.Render(w, r, Data{Next: next})
+			return
+		}
+
+		u, err := a.authenticate(r, strings.TrimSpace(r.PostFormValue("token")))
+		var tooMany *tooManyTokens
+		if errors.As(err, &tooMany) {
+			log.Printf("%s %s: %v\n", r.Method, r.URL, err)
+			rr.Render(w, r, Data{Next: next, Message: fmt.Sprintf("Too many attempts, try again in %d seconds.", retryAfter(tooMany.wait))})
+			return
+		} else if err != nil {
+			log.Printf("%s %s: %v\n", r.Method, r.URL, err)
+			rr.Render(w, r, Data{Next: next, Message: "That token is not valid."})
+			return
+		}
+		id, err := a.sessions.Create(u)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("%s %s: %s logged in\n", r.Method, r.URL, u.Name)
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookie,
+			Value:    id,
+			Path:     "/",
+			MaxAge:   int(a.sessions.TTL().Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	}
+}
+
+// localPage returns next if it is one of our own pages, otherwise "/".
+// Browsers ignore tabs and newlines in URLs and treat backslashes like
+// slashes, so anything that could turn into "//host" is refused.
+func localPage(next string) string {
+	for _, ch := range next {
+		if ch < ' ' || ch == 0x7f || ch == '\\' {
+			return "/"
+		}
+	}
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil || !strings.HasPrefix(u.Path, "/") {
+		return "/"
+	}
+	next = u.RequestURI()
+	if strings.HasPrefix(next, "//") {
+		return "/"
+	}
+	return next
+}
+
+// logoutHandler ends the session.
+func (a *authenticator) logoutHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie(sessionCookie); err == nil {
+			a.sessions.Delete(c.Value)
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookie,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
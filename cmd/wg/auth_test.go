@@ -0,0 +1,40 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import "testing"
+
+func TestLocalPage(t *testing.T) {
+	for _, tc := range []struct {
+		next, want string
+	}{
+		{"/view/abc", "/view/abc"},
+		{"/gallery?q=abc", "/gallery?q=abc"},
+		{"", "/"},
+		{"gallery", "/"},
+		{"//evil.example", "/"},
+		{"/\\evil.example", "/"},
+		{"/\t/evil.example", "/"},
+		{"/\n/evil.example", "/"},
+		{"https://evil.example/", "/"},
+		{"javascript:alert(1)", "/"},
+	} {
+		if got := localPage(tc.next); got != tc.want {
+			t.Errorf("%q: want %q, got %q", tc.next, tc.want, got)
+		}
+	}
+}
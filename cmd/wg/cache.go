@@ -0,0 +1,417 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mdhender/worldgen/pkg/way"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// renderCache keeps rendered responses in memory and on disk, keyed by a hash
+// of the request. Maps never change once they are created, so the same path
+// and query always render the same bytes and the hash can be used as the ETag
+// without rendering anything. The version of the code is part of the hash, so
+// a new build doesn't serve old renders. Palette and name files are not, so
+// restart with an empty cache directory after changing them.
+type renderCache struct {
+	dir     string
+	version string
+
+	sync.Mutex
+	mem  *lru // key to *cacheEntry
+	disk *lru // key to nothing, just tracks the files
+}
+
+// cacheEntry is a rendered response.
+type cacheEntry struct {
+	Header map[string]string `json:"header"`
+	Body   []byte            `json:"-"`
+}
+
+// cachedHeaders are the response headers that are saved with the body.
+var cachedHeaders = []string{"Content-Type", "Content-Disposition"}
+
+// newRenderCache creates a cache that holds up to memBytes in memory and
+// diskBytes in the directory. Files already in the directory are kept,
+// with the most recently used evicted last.
+func newRenderCache(dir string, memBytes, diskBytes int64, version string) (*renderCache, error) {
+	c := &renderCache{
+		dir:     dir,
+		version: version,
+		mem:     newLRU(memBytes),
+		disk:    newLRU(diskBytes),
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type file struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".cache") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file{strings.TrimSuffix(entry.Name(), ".cache"), info.Size(), info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, f := range files {
+		c.removeFiles(c.disk.add(f.key, nil, f.size))
+	}
+	log.Printf("cache: %s: %d renders, %d bytes\n", dir, c.disk.ll.Len(), c.disk.size)
+	return c, nil
+}
+
+// key returns the cache key for the request. It is the hash of the path
+// and the query with its parameters sorted, so that the order of the
+// parameters doesn't matter.
+func (c *renderCache) key(r *http.Request) string {
+	return c.keyFor(way.Param(r.Context(), "name"), r.URL.Path, r.URL.Query())
+}
+
+// keyFor returns the cache key for a render of the named map. The name
+// starts the key, so that forget can find the renders of a map.
+func (c *renderCache) keyFor(name, path string, params url.Values) string {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%s\x00%s\x00%s", c.version, path, params.Encode())
+	if !isMapName(name) {
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	return name + "." + hex.EncodeToString(h.Sum(nil))
+}
+
+// forget removes the renders of a map that has been deleted.
+func (c *renderCache) forget(name string) {
+	c.Lock()
+	defer c.Unlock()
+	c.mem.removePrefix(name + ".")
+	c.removeFiles(c.disk.removePrefix(name + "."))
+}
+
+// handler serves responses from the cache, calling h to render them when needed.
+// Only successful responses are cached. Renders of a map that has been
+// deleted are not served, so h gets the chance to report that it's gone.
+func (c *renderCache) handler(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if name := way.Param(r.Context(), "name"); name != "" && isMapName(name) && !mapExists(name) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		c.serve(w, r, c.key(r), h)
+	}
+}
+
+// serve writes the response for the key, calling h to render it if it isn't cached.
+func (c *renderCache) serve(w http.ResponseWriter, r *http.Request, key string, h http.Handler) {
+	etag := `"` + key + `"`
+	w.Header().Set("ETag", etag)
+	if _, err := r.Cookie(sessionCookie); err == nil || r.Header.Get("Authorization") != "" {
+		// shared caches must not hand a logged in user's renders to anyone else
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+	}
+	// only a render that is cached is known to be valid, the request
+	// could have bad parameters or be for a map that doesn't exist
+	if ifNoneMatch(r.Header.Get("If-None-Match"), etag) && c.has(key) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	e, where := c.get(key)
+	if e == nil {
+		rec := &recorder{header: http.Header{}, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+		if rec.status != http.StatusOK {
+			// errors go straight to the client, without the cache headers
+			w.Header().Del("ETag")
+			w.Header().Del("Cache-Control")
+			for k, v := range rec.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(rec.status)
+			_, _ = w.Write(rec.body.Bytes())
+			return
+		}
+		e = &cacheEntry{Header: map[string]string{}, Body: rec.body.Bytes()}
+		for _, k := range cachedHeaders {
+			if v := rec.header.Get(k); v != "" {
+				e.Header[k] = v
+			}
+		}
+		c.put(key, e)
+		where = "miss"
+	}
+
+	for k, v := range e.Header {
+		w.Header().Set(k, v)
+	}
+	w.Header().Set("X-Cache", where)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(e.Body)
+}
+
+// get returns the entry from memory or disk, and where it was found.
+// It returns nil if the entry isn't cached.
+func (c *renderCache) get(key string) (*cacheEntry, string) {
+	c.Lock()
+	if v, ok := c.mem.get(key); ok {
+		c.Unlock()
+		return v.(*cacheEntry), "memory"
+	}
+	_, onDisk := c.disk.get(key)
+	c.Unlock()
+	if !onDisk {
+		return nil, ""
+	}
+
+	e, err := c.read(key)
+	if err != nil {
+		log.Printf("cache: %s: %v\n", key, err)
+		c.Lock()
+		c.disk.remove(key)
+		c.Unlock()
+		return nil, ""
+	}
+	// keep the file's age in step with its place in the list for the next restart
+	now := time.Now()
+	_ = os.Chtimes(c.path(key), now, now)
+	c.Lock()
+	c.mem.add(key, e, int64(len(e.Body)))
+	c.Unlock()
+	return e, "disk"
+}
+
+// has returns true if the entry is in memory or on disk.
+func (c *renderCache) has(key string) bool {
+	c.Lock()
+	defer c.Unlock()
+	_, inMem := c.mem.items[key]
+	_, onDisk := c.disk.items[key]
+	return inMem || onDisk
+}
+
+// put adds the entry to memory and disk, evicting the least recently used entries.
+func (c *renderCache) put(key string, e *cacheEntry) {
+	size, err := c.write(key, e)
+	c.Lock()
+	defer c.Unlock()
+	c.mem.add(key, e, int64(len(e.Body)))
+	if err != nil {
+		log.Printf("cache: %s: %v\n", key, err)
+		return
+	}
+	c.removeFiles(c.disk.add(key, nil, size))
+}
+
+func (c *renderCache) path(key string) string {
+	return filepath.Join(c.dir, key+".cache")
+}
+
+// read loads an entry from disk. The file is a line of JSON with the
+// headers followed by the body.
+func (c *renderCache) read(key string) (*cacheEntry, error) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, err
+	}
+	n := bytes.IndexByte(data, '\n')
+	if n < 0 {
+		return nil, errors.New("missing header")
+	}
+	e := &cacheEntry{}
+	if err = json.Unmarshal(data[:n], e); err != nil {
+		return nil, err
+	}
+	e.Body = data[n+1:]
+	return e, nil
+}
+
+// write saves an entry to disk and returns the size of the file.
+// Like saveMap, it writes a temporary file and renames it.
+func (c *renderCache) write(key string, e *cacheEntry) (int64, error) {
+	header, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	fp, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	bw := bufio.NewWriter(fp)
+	_, _ = bw.Write(header)
+	_ = bw.WriteByte('\n')
+	_, _ = bw.Write(e.Body)
+	if err = bw.Flush(); err != nil {
+		_ = fp.Close()
+		_ = os.Remove(fp.Name())
+		return 0, err
+	} else if err = fp.Close(); err != nil {
+		_ = os.Remove(fp.Name())
+		return 0, err
+	}
+	if err = os.Rename(fp.Name(), c.path(key)); err != nil {
+		_ = os.Remove(fp.Name())
+		return 0, err
+	}
+	return int64(len(header) + 1 + len(e.Body)), nil
+}
+
+// removeFiles deletes the files of entries evicted from the disk cache.
+func (c *renderCache) removeFiles(keys []string) {
+	for _, key := range keys {
+		if err := os.Remove(c.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("cache: %v\n", err)
+		}
+	}
+}
+
+// ifNoneMatch returns true if the If-None-Match header matches the ETag.
+// "*" is meant for conditional writes, so it doesn't match here.
+func ifNoneMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// recorder captures a response so that it can be cached.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+	return rec.body.Write(p)
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	rec.status = status
+}
+
+// lru is a list of keys with sizes, most recently used first.
+// It is not safe for concurrent use.
+type lru struct {
+	max, size int64
+	ll        *list.List
+	items     map[string]*list.Element
+}
+
+type lruItem struct {
+	key   string
+	value any
+	size  int64
+}
+
+func newLRU(max int64) *lru {
+	return &lru{max: max, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+// get returns the value for the key and marks it as recently used.
+func (l *lru) get(key string) (any, bool) {
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	l.ll.MoveToFront(el)
+	return el.Value.(*lruItem).value, true
+}
+
+// add adds or replaces the value for the key and returns the keys that
+// were evicted to make room for it. A value larger than the whole cache
+// isn't added, so it doesn't push everything else out, and its key is
+// returned as evicted.
+func (l *lru) add(key string, value any, size int64) (evicted []string) {
+	if size > l.max {
+		l.remove(key)
+		return []string{key}
+	}
+	if el, ok := l.items[key]; ok {
+		item := el.Value.(*lruItem)
+		l.size += size - item.size
+		item.value, item.size = value, size
+		l.ll.MoveToFront(el)
+	} else {
+		l.items[key] = l.ll.PushFront(&lruItem{key: key, value: value, size: size})
+		l.size += size
+	}
+	for l.size > l.max && l.ll.Len() != 0 {
+		item := l.ll.Remove(l.ll.Back()).(*lruItem)
+		delete(l.items, item.key)
+		l.size -= item.size
+		evicted = append(evicted, item.key)
+	}
+	return evicted
+}
+
+// remove forgets the key.
+func (l *lru) remove(key string) {
+	if el, ok := l.items[key]; ok {
+		l.size -= el.Value.(*lruItem).size
+		l.ll.Remove(el)
+		delete(l.items, key)
+	}
+}
+
+// removePrefix forgets all the keys that start with prefix and returns them.
+func (l *lru) removePrefix(prefix string) (removed []string) {
+	for key := range l.items {
+		if strings.HasPrefix(key, prefix) {
+			removed = append(removed, key)
+		}
+	}
+	for _, key := range removed {
+		l.remove(key)
+	}
+	return removed
+}
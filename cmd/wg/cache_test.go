@@ -0,0 +1,118 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestLRU(t *testing.T) {
+	l := newLRU(10)
+	if evicted := l.add("a", 1, 4); evicted != nil {
+		t.Fatalf("a: want nothing evicted, got %v", evicted)
+	} else if evicted = l.add("b", 2, 4); evicted != nil {
+		t.Fatalf("b: want nothing evicted, got %v", evicted)
+	}
+
+	// using a makes b the least recently used
+	if v, ok := l.get("a"); !ok || v != 1 {
+		t.Fatalf("a: want 1, got %v %v", v, ok)
+	}
+	if evicted := l.add("c", 3, 4); !reflect.DeepEqual(evicted, []string{"b"}) {
+		t.Fatalf("c: want [b] evicted, got %v", evicted)
+	} else if _, ok := l.get("b"); ok {
+		t.Fatal("b: want it gone")
+	}
+
+	// replacing a value changes the size
+	if evicted := l.add("a", 4, 2); evicted != nil {
+		t.Fatalf("a: want nothing evicted, got %v", evicted)
+	} else if l.size != 6 {
+		t.Fatalf("size: want 6, got %d", l.size)
+	}
+
+	// a value larger than the cache isn't added and doesn't evict anything
+	if evicted := l.add("d", 5, 11); !reflect.DeepEqual(evicted, []string{"d"}) {
+		t.Fatalf("d: want [d] evicted, got %v", evicted)
+	} else if l.size != 6 || l.ll.Len() != 2 {
+		t.Fatalf("want 2 items of 6 bytes, got %d items of %d bytes", l.ll.Len(), l.size)
+	} else if _, ok := l.get("d"); ok {
+		t.Fatal("d: want it gone")
+	}
+}
+
+func TestLRURemovePrefix(t *testing.T) {
+	l := newLRU(100)
+	for _, key := range []string{"abc.1", "abc.2", "abc-1000.1", "def.1"} {
+		l.add(key, nil, 1)
+	}
+	removed := l.removePrefix("abc.")
+	if len(removed) != 2 {
+		t.Fatalf("want 2 removed, got %v", removed)
+	} else if _, ok := l.get("abc-1000.1"); !ok {
+		t.Fatal("abc-1000.1: want it kept")
+	} else if l.size != 2 {
+		t.Fatalf("size: want 2, got %d", l.size)
+	}
+}
+
+func TestRenderCacheNotModified(t *testing.T) {
+	c, err := newRenderCache(t.TempDir(), 1<<20, 1<<20, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := http.StatusBadRequest
+	h := c.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			http.Error(w, "invalid width", status)
+			return
+		}
+		_, _ = w.Write([]byte("image"))
+	}))
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/render?width=1", nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	// nothing is cached, so the handler decides
+	etag := `"` + c.keyFor("", "/render", url.Values{"width": {"1"}}) + `"`
+	for _, tag := range []string{"*", etag} {
+		if rec := get(tag); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: want %d, got %d", tag, http.StatusBadRequest, rec.Code)
+		}
+	}
+
+	status = http.StatusOK
+	if rec := get(""); rec.Code != http.StatusOK || rec.Header().Get("ETag") != etag {
+		t.Fatalf("want %d with %s, got %d with %s", http.StatusOK, etag, rec.Code, rec.Header().Get("ETag"))
+	}
+	if rec := get(etag); rec.Code != http.StatusNotModified {
+		t.Errorf("cached: want %d, got %d", http.StatusNotModified, rec.Code)
+	}
+	if rec := get("*"); rec.Code != http.StatusOK {
+		t.Errorf("cached: *: want %d, got %d", http.StatusOK, rec.Code)
+	}
+}
@@ -0,0 +1,220 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// config is the server's configuration. It starts with the defaults, then
+// the JSON file named by -config, if any, then the other flags, so a flag
+// always wins over the file. The file uses the names of the json tags:
+//
+//	{
+//	  "listen": ":8443",
+//	  "tls_cert": "/etc/wg/cert.pem",
+//	  "tls_key": "/etc/wg/key.pem",
+//	  "data_dir": "/var/lib/wg",
+//	  "width": 1200, "height": 600, "iterations": 10000,
+//	  "generators": ["smite", "tiled"]
+//	}
+//
+// Relative paths in the file are relative to the directory the server is
+// started in, like the flags.
+type config struct {
+	Listen  string `json:"listen"`
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+
+	DataDir   string `json:"data_dir"` // cached maps and renders
+	Templates string `json:"templates"`
+	Public    string `json:"public"`
+	Palettes  string `json:"palettes"`
+	Names     string `json:"names"`
+	Users     string `json:"users"` // users file, see auth.LoadUsers
+
+	// size of new worlds, and the most that can be asked for
+	Height        int `json:"height"`
+	Width         int `json:"width"`
+	Iterations    int `json:"iterations"`
+	MaxHeight     int `json:"max_height"`
+	MaxWidth      int `json:"max_width"`
+	MaxIterations int `json:"max_iterations"` // including faults added to a world
+
+	Workers    int `json:"workers"`
+	QueueDepth int `json:"queue_depth"`
+
+	// the older generators that are served, each at /<name>/:seed
+	Generators stringList `json:"generators"`
+}
+
+// generators are the older generators that can be enabled.
+var generators = []string{"asteroids", "fracture", "smite", "tile", "tiled"}
+
+func defaultConfig() *config {
+	return &config{
+		Listen:        ":8080",
+		DataDir:       ".",
+		Templates:     filepath.Join("..", "templates"),
+		Public:        filepath.Join("..", "public"),
+		Palettes:      filepath.Join("..", "palettes"),
+		Names:         filepath.Join("..", "names"),
+		Users:         os.Getenv("WMG_USERS"),
+		Height:        600,
+		Width:         1_200,
+		Iterations:    10_000,
+		MaxHeight:     2_400,
+		MaxWidth:      4_800,
+		MaxIterations: 100_000,
+		Workers:       runtime.NumCPU(),
+		QueueDepth:    16,
+	}
+}
+
+// loadConfig returns the configuration from the command line arguments.
+func loadConfig(args []string) (*config, error) {
+	cfg := defaultConfig()
+	fs, configFile := cfg.flags()
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *configFile != "" {
+		// start over with the file, then let the flags override it
+		cfg = defaultConfig()
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err = dec.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", *configFile, err)
+		}
+		fs, _ = cfg.flags()
+		if err = fs.Parse(args); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// flags returns the command line flags, with the current values as the defaults.
+func (cfg *config) flags() (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet("wg", flag.ContinueOnError)
+	configFile := fs.String("config", "", "JSON configuration file")
+	fs.StringVar(&cfg.Listen, "listen", cfg.Listen, "address to listen on")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", cfg.TLSCert, "TLS certificate file, serves HTTPS when set with -tls-key")
+	fs.StringVar(&cfg.TLSKey, "tls-key", cfg.TLSKey, "TLS key file")
+	fs.StringVar(&cfg.DataDir, "data", cfg.DataDir, "directory for cached maps and renders")
+	fs.StringVar(&cfg.Templates, "templates", cfg.Templates, "directory of page templates")
+	fs.StringVar(&cfg.Public, "public", cfg.Public, "directory of static files")
+	fs.StringVar(&cfg.Palettes, "palettes", cfg.Palettes, "directory of palettes")
+	fs.StringVar(&cfg.Names, "names", cfg.Names, "directory of name corpora")
+	fs.StringVar(&cfg.Users, "users", cfg.Users, "users file with API tokens")
+	fs.IntVar(&cfg.Height, "height", cfg.Height, "height of new worlds")
+	fs.IntVar(&cfg.Width, "width", cfg.Width, "width of new worlds")
+	fs.IntVar(&cfg.Iterations, "iterations", cfg.Iterations, "faults in new worlds")
+	fs.IntVar(&cfg.MaxHeight, "max-height", cfg.MaxHeight, "largest height that can be asked for")
+	fs.IntVar(&cfg.MaxWidth, "max-width", cfg.MaxWidth, "largest width that can be asked for")
+	fs.IntVar(&cfg.MaxIterations, "max-iterations", cfg.MaxIterations, "most faults that a world can have")
+	fs.IntVar(&cfg.Workers, "workers", cfg.Workers, "worlds that can be created at the same time")
+	fs.IntVar(&cfg.QueueDepth, "queue-depth", cfg.QueueDepth, "worlds that can wait to be created")
+	fs.Var(&cfg.Generators, "generators", "comma separated older generators to serve: "+strings.Join(generators, ", "))
+	return fs, configFile
+}
+
+func (cfg *config) validate() error {
+	if cfg.Listen == "" {
+		return errors.New("listen: missing address")
+	} else if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return errors.New("tls: both the certificate and the key are needed")
+	} else if cfg.Height < 1 || cfg.Height > cfg.MaxHeight {
+		return fmt.Errorf("height: must be between 1 and %d", cfg.MaxHeight)
+	} else if cfg.Width < 1 || cfg.Width > cfg.MaxWidth {
+		return fmt.Errorf("width: must be between 1 and %d", cfg.MaxWidth)
+	} else if cfg.Iterations < 1 || cfg.Iterations > cfg.MaxIterations {
+		return fmt.Errorf("iterations: must be between 1 and %d", cfg.MaxIterations)
+	} else if cfg.Workers < 1 {
+		return errors.New("workers: must be at least 1")
+	} else if cfg.QueueDepth < 0 {
+		return errors.New("queue_depth: must not be negative")
+	}
+	for _, name := range cfg.Generators {
+		if n := sort.SearchStrings(generators, name); n == len(generators) || generators[n] != name {
+			return fmt.Errorf("%q: unknown generator", name)
+		}
+	}
+	return nil
+}
+
+// chdir makes the paths absolute and changes to the data directory,
+// where maps and renders are cached.
+func (cfg *config) chdir() error {
+	for _, path := range []*string{&cfg.TLSCert, &cfg.TLSKey, &cfg.Templates, &cfg.Public, &cfg.Palettes, &cfg.Names, &cfg.Users} {
+		if *path == "" {
+			continue
+		}
+		abs, err := filepath.Abs(*path)
+		if err != nil {
+			return err
+		}
+		*path = abs
+	}
+	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		return err
+	}
+	return os.Chdir(cfg.DataDir)
+}
+
+// allows returns true if the older generator is enabled.
+func (cfg *config) allows(generator string) bool {
+	for _, name := range cfg.Generators {
+		if name == generator {
+			return true
+		}
+	}
+	return false
+}
+
+// stringList is a comma separated flag that is a list in the config file.
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(value string) error {
+	*sl = nil
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*sl = append(*sl, s)
+		}
+	}
+	return nil
+}
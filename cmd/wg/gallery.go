@@ -0,0 +1,177 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mdhender/worldgen/pkg/auth"
+	"github.com/mdhender/worldgen/pkg/gen"
+	"github.com/mdhender/worldgen/pkg/way"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// gallery lists the maps in the cache.
+type gallery struct {
+	sync.Mutex
+	// counting the regions means loading the whole map, so the counts are
+	// kept until the map is deleted. Maps never change once they are created.
+	summaries map[string]worldSummary
+}
+
+// worldSummary counts the regions of a map with the default water and ice.
+// Like the labels, it ignores lakes, islands and ice that are too small to name.
+type worldSummary struct {
+	Continents, Islands int
+	Oceans, Lakes       int
+	IceCaps             int
+}
+
+func newGallery() *gallery {
+	return &gallery{summaries: make(map[string]worldSummary)}
+}
+
+// summary returns the region counts for the map.
+func (g *gallery) summary(name string) (worldSummary, error) {
+	g.Lock()
+	s, ok := g.summaries[name]
+	g.Unlock()
+	if ok {
+		return s, nil
+	}
+
+	m, err := loadMap(name)
+	if err != nil {
+		return s, err
+	}
+	for _, region := range m.Regions(55, 8).List {
+		if region.Kind != gen.Continent && region.Kind != gen.Ocean && region.Area < minLabeledRegion {
+			continue
+		}
+		switch region.Kind {
+		case gen.Continent:
+			s.Continents++
+		case gen.Island:
+			s.Islands++
+		case gen.Ocean:
+			s.Oceans++
+		case gen.Lake:
+			s.Lakes++
+		case gen.IceCap:
+			s.IceCaps++
+		}
+	}
+
+	g.Lock()
+	g.summaries[name] = s
+	g.Unlock()
+	return s, nil
+}
+
+// handler returns a page with a thumbnail and the metadata of every map in
+// the cache, newest first. Searching and filtering are done by the page.
+func (g *gallery) handler(a *authenticator, root string) http.HandlerFunc {
+	root = filepath.Clean(root)
+	rr := Renderer{}
+	for _, tmpl := range []string{"layout", "gallery"} {
+		rr.files = append(rr.files, filepath.Join(root, tmpl+".gohtml"))
+	}
+	log.Printf("gallery: %v\n", rr.files)
+
+	type World struct {
+		Name string
+		gen.Metadata
+		worldSummary
+	}
+	type Data struct {
+		Worlds     []World
+		Generators []string
+		CanDelete  bool
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		names, err := cachedMaps()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+
+		u, _ := a.user(r)
+		data := Data{CanDelete: u.Can(auth.RoleGenerate)}
+		generators := map[string]bool{}
+		for _, name := range names {
+			md, err := loadMetadata(name)
+			if err != nil {
+				// one bad file shouldn't hide the rest of the cache
+				log.Printf("%s %s: %s: %v\n", r.Method, r.URL, name, err)
+				continue
+			}
+			s, err := g.summary(name)
+			if err != nil {
+				log.Printf("%s %s: %s: %v\n", r.Method, r.URL, name, err)
+				continue
+			}
+			data.Worlds = append(data.Worlds, World{Name: name, Metadata: md, worldSummary: s})
+			if md.Generator != "" && !generators[md.Generator] {
+				generators[md.Generator] = true
+				data.Generators = append(data.Generators, md.Generator)
+			}
+		}
+		sort.SliceStable(data.Worlds, func(i, j int) bool {
+			return data.Worlds[i].Created.After(data.Worlds[j].Created)
+		})
+		sort.Strings(data.Generators)
+
+		rr.Render(w, r, data)
+	}
+}
+
+// deleteHandler removes a map from the cache and returns to the gallery.
+// Anything else that holds on to the map's renders is told to forget them.
+func (g *gallery) deleteHandler(forget ...func(name string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := way.Param(r.Context(), "name")
+		if !isMapName(name) {
+			http.Error(w, "invalid map name", http.StatusBadRequest)
+			return
+		}
+
+		err := deleteMap(name)
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("%s %s: deleted %s\n", r.Method, r.URL, name)
+
+		g.Lock()
+		delete(g.summaries, name)
+		g.Unlock()
+		for _, fn := range forget {
+			fn(name)
+		}
+
+		http.Redirect(w, r, "/gallery", http.StatusSeeOther)
+	}
+}
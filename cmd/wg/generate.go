@@ -17,32 +17,105 @@
 package main
 
 import (
-	"crypto/sha1"
-	"encoding/base64"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/mdhender/worldgen/pkg/auth"
 	"github.com/mdhender/worldgen/pkg/cmap"
 	"github.com/mdhender/worldgen/pkg/gen"
 	"log"
-	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
-	"sync"
+	"strings"
 	"time"
 )
 
-func generateHandler(height, width, iterations int) http.HandlerFunc {
-	var lock sync.Mutex
-	secret := os.Getenv("WMG_SECRET")
+// worlds creates maps and saves them in the cache.
+// Creating a map takes a while, so maps are created by the job queue.
+type worlds struct {
+	height, width, iterations int
+	maxIterations             int // including added faults
+}
+
+func newWorlds(height, width, iterations, maxIterations int) *worlds {
+	return &worlds{height: height, width: width, iterations: iterations, maxIterations: maxIterations}
+}
+
+// checkFaults returns an error if the faults can't be added to a world.
+func (ws *worlds) checkFaults(addFaults int) error {
+	if addFaults < 0 {
+		return fmt.Errorf("%q: must not be negative", "add_faults")
+	} else if addFaults > ws.maxIterations-ws.iterations {
+		return fmt.Errorf("%q: worlds can have at most %d faults", "add_faults", ws.maxIterations)
+	}
+	return nil
+}
+
+// generateTimeout is the longest we'll spend creating one map.
+const generateTimeout = 5 * time.Minute
+
+// create returns the cached map for the seed, creating it if needed.
+// It reports whether the map was created. The progress function, if
+// not nil, is called as the faults are added. If the context is done
+// before the map is finished, nothing is saved and the context's error
+// is returned.
+func (ws *worlds) create(ctx context.Context, seed uint64, addFaults int, progress func(done, total int)) (name string, m *gen.Map, created bool, err error) {
+	name = ws.mapName(seed, addFaults)
+
+	// the job queue only runs one job for each name, but the map may have
+	// been saved since the handler first looked for it
+	if m, err = loadMap(name); err == nil {
+		return name, m, false, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return name, nil, false, err
+	}
+
+	// continue from the base map if we have it, otherwise generate from scratch.
+	// the results are the same either way, but continuing is much faster.
+	if addFaults != 0 {
+		if m, err = loadMap(ws.mapName(seed, 0)); err == nil {
+			m.OnProgress(progress)
+			if err = m.AddFaultsContext(ctx, addFaults); err != nil && ctx.Err() != nil {
+				return name, nil, false, err
+			} else if err != nil {
+				log.Printf("worlds: %s: %v\n", name, err)
+				m = nil
+			} else {
+				log.Printf("worlds: %s: added %d faults\n", name, addFaults)
+			}
+		}
+	}
+	if m == nil {
+		m = gen.FromSeed(ws.height, ws.width, int64(seed))
+		m.OnProgress(progress)
+		if err = m.RandomFractureCircleContext(ctx, ws.iterations+addFaults); err != nil {
+			return name, nil, false, err
+		}
+		m.Normalize()
+	}
 
+	// save it
+	if err = saveMap(name, m); err != nil {
+		return name, nil, false, err
+	}
+	log.Printf("worlds: created %s.wgm\n", name)
+	return name, m, true, nil
+}
+
+// generateHandler returns an image of the map from the web form.
+// New maps are created by the job queue, and the handler waits for them.
+func generateHandler(a *authenticator, lim *limits, ws *worlds, jobs *jobQueue, rc *renderCache, pals palettes) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		started := time.Now()
 		log.Printf("%s %s: entering\n", r.Method, r.URL)
-		lock.Lock()
 		defer func() {
-			lock.Unlock()
-			log.Printf("%s %s: elapsed %vn", r.Method, r.URL, time.Now().Sub(started))
+			log.Printf("%s %s: elapsed %v\n", r.Method, r.URL, time.Now().Sub(started))
 		}()
 
 		if err := r.ParseForm(); err != nil {
@@ -56,25 +129,23 @@ func generateHandler(height, width, iterations int) http.HandlerFunc {
 		// get form values
 		var err error
 		var input struct {
-			fname            string
+			name             string
 			seed             uint64
-			height, width    int
-			iterations       int
+			addFaults        int
 			pctWater, pctIce int
 			shiftX, shiftY   int
-			secret           string
+			palette          *cmap.Palette
 		}
-		input.height = height
-		input.width = width
-		input.iterations = iterations
 		if input.seed, err = pfvAsUint(r, "seed"); err != nil {
 		} else if input.pctIce, err = pfvAsInt(r, "pct_ice"); err != nil {
 		} else if input.pctWater, err = pfvAsInt(r, "pct_water"); err != nil {
 		} else if input.shiftX, err = pfvAsInt(r, "shift_x"); err != nil {
 		} else if input.shiftY, err = pfvAsInt(r, "shift_y"); err != nil {
-		} else if input.secret, _ = pfvAsString(r, "secret"); err != nil {
+		} else if input.addFaults, err = pfvAsOptInt(r, "add_faults", 0); err != nil {
+		} else if err = ws.checkFaults(input.addFaults); err != nil {
+		} else if input.palette, err = pals.get(r.PostFormValue("palette")); err != nil {
 		} else {
-			input.fname = fmt.Sprintf("%x.json", input.seed)
+			input.name = ws.mapName(input.seed, input.addFaults)
 		}
 		if err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
@@ -82,44 +153,37 @@ func generateHandler(height, width, iterations int) http.HandlerFunc {
 		}
 		log.Printf("%s %s: %+v\n", r.Method, r.URL, input)
 
-		var m *gen.Map
-
 		// does map already exist?
-		data, err := os.ReadFile(input.fname)
-		if err == nil {
-			// use it
-			m = &gen.Map{}
-			if err = json.Unmarshal(data, m); err != nil {
-				http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+		m, err := loadMap(input.name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		} else if m == nil {
+			// anyone who can view maps can ask for a cached one, but creating one takes more
+			if !a.allowed(w, r, auth.RoleGenerate) || !lim.allowed(w, r, lim.generate) {
 				return
 			}
-		} else {
-			hh := sha1.New()
-			hh.Write([]byte(input.secret))
-			sis := base64.URLEncoding.EncodeToString(hh.Sum(nil))
-			hh = sha1.New()
-			hh.Write([]byte(secret))
-			sss := base64.URLEncoding.EncodeToString(hh.Sum(nil))
-			if sis != sss {
-				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			// the job is cancelled if we stop waiting for it and nobody else is
+			j, err := jobs.submit(input.seed, input.addFaults, true)
+			if errors.Is(err, errQueueFull) {
+				http.Error(w, fmt.Sprintf("%v", err), http.StatusServiceUnavailable)
+				return
+			} else if err != nil {
+				http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
 				return
 			}
-
-			// generate it
-			m = gen.New(input.height, input.width, rand.New(rand.NewSource(int64(input.seed))))
-			m.RandomFractureCircle(input.iterations)
-			m.Normalize()
-
-			// save it
-			data, err := json.Marshal(m)
+			status, err := j.wait(r.Context())
+			j.release()
 			if err != nil {
-				http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+				log.Printf("%s %s: %v\n", r.Method, r.URL, err)
+				return
+			} else if status.State != jobDone {
+				http.Error(w, status.Error, http.StatusInternalServerError)
 				return
-			} else if err = os.WriteFile(input.fname, data, 0644); err != nil {
+			}
+			if m, err = loadMap(input.name); err != nil {
 				http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
 				return
-			} else {
-				log.Printf("%s %s: json: created %s\n", r.Method, r.URL, input.fname)
 			}
 		}
 
@@ -129,26 +193,135 @@ func generateHandler(height, width, iterations int) http.HandlerFunc {
 			return
 		}
 
-		if input.shiftX != 0 {
-			m.ShiftX(-1 * m.Width() * input.shiftX / 100)
+		// the image only depends on these, so the same request is served from the cache
+		key := rc.keyFor(input.name, "/generate/"+input.name, url.Values{
+			"pct_water": {strconv.Itoa(input.pctWater)},
+			"pct_ice":   {strconv.Itoa(input.pctIce)},
+			"shift_x":   {strconv.Itoa(input.shiftX)},
+			"shift_y":   {strconv.Itoa(input.shiftY)},
+			"palette":   {r.PostFormValue("palette")},
+		})
+		rc.serve(w, r, key, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeCarto(w, m, cartoOptions{
+				pctWater: input.pctWater,
+				pctIce:   input.pctIce,
+				shiftX:   input.shiftX,
+				shiftY:   input.shiftY,
+				palette:  input.palette,
+			})
+		}))
+	}
+}
+
+// the size and faults of maps from before they could be configured
+const stdHeight, stdWidth, stdIterations = 600, 1_200, 10_000
+
+// mapName returns the name used to cache a map. The name holds everything
+// that the map depends on, so maps created with other settings don't get
+// mixed up. Maps with the original settings keep their original names.
+func (ws *worlds) mapName(seed uint64, addFaults int) string {
+	faults := ws.iterations + addFaults
+	if ws.height != stdHeight || ws.width != stdWidth {
+		return fmt.Sprintf("%x-%d-%d-%d", seed, faults, ws.height, ws.width)
+	} else if faults != stdIterations {
+		return fmt.Sprintf("%x-%d", seed, faults)
+	}
+	return fmt.Sprintf("%x", seed)
+}
+
+// mapExists returns true if the map is in the cache.
+func mapExists(name string) bool {
+	for _, ext := range []string{".wgm", ".json"} {
+		if _, err := os.Stat(name + ext); err == nil {
+			return true
 		}
-		if input.shiftY != 0 {
-			m.ShiftY(m.Height() * input.shiftY / 100)
+	}
+	return false
+}
+
+// deleteMap removes a map from the cache, along with any older JSON file.
+// It returns os.ErrNotExist if there was nothing to remove.
+func deleteMap(name string) error {
+	found := false
+	for _, ext := range []string{".wgm", ".json"} {
+		if err := os.Remove(name + ext); err == nil {
+			found = true
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
 		}
+	}
+	if !found {
+		return os.ErrNotExist
+	}
+	return nil
+}
 
-		// generate color map
-		cm := cmap.FromHistogram(m.Histogram(), input.pctWater, input.pctIce, cmap.Water, cmap.Terrain, cmap.Ice)
+// cachedMaps returns the names of the maps in the cache, sorted.
+func cachedMaps() ([]string, error) {
+	seen := map[string]bool{}
+	var list []string
+	for _, ext := range []string{".wgm", ".json"} {
+		paths, err := filepath.Glob("*" + ext)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			name := strings.TrimSuffix(path, ext)
+			if isMapName(name) && !seen[name] {
+				seen[name] = true
+				list = append(list, name)
+			}
+		}
+	}
+	sort.Strings(list)
+	return list, nil
+}
 
-		png, err := m.AsPNG(m.AsCarto(cm))
+// loadMap loads a cached map.
+// It falls back to the older JSON files if there's no binary file.
+func loadMap(name string) (*gen.Map, error) {
+	fp, err := os.Open(name + ".wgm")
+	if errors.Is(err, os.ErrNotExist) {
+		data, err := os.ReadFile(name + ".json")
 		if err != nil {
-			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
-			return
+			return nil, err
+		}
+		m := &gen.Map{}
+		if err = json.Unmarshal(data, m); err != nil {
+			return nil, err
 		}
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	return gen.ReadBinary(fp)
+}
 
-		w.Header().Set("Content-Type", "image/png")
-		w.WriteHeader(http.StatusOK)
-		w.Write(png)
+// saveMap saves the map using the binary format.
+// The file is written under a temporary name and then renamed,
+// so that readers never see a partly written map.
+func saveMap(name string, m *gen.Map) error {
+	bb := &bytes.Buffer{}
+	if err := m.WriteBinary(bb); err != nil {
+		return err
+	}
+	fp, err := os.CreateTemp(".", name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = fp.Write(bb.Bytes()); err != nil {
+		_ = fp.Close()
+		_ = os.Remove(fp.Name())
+		return err
+	} else if err = fp.Close(); err != nil {
+		_ = os.Remove(fp.Name())
+		return err
+	} else if err = os.Chmod(fp.Name(), 0644); err != nil {
+		_ = os.Remove(fp.Name())
+		return err
 	}
+	return os.Rename(fp.Name(), name+".wgm")
 }
 
 // helper functions
@@ -164,6 +337,14 @@ func pfvAsInt(r *http.Request, key string) (int, error) {
 	return val, nil
 }
 
+// pfvAsOptInt returns the default value if the field is missing.
+func pfvAsOptInt(r *http.Request, key string, dflt int) (int, error) {
+	if r.PostFormValue(key) == "" {
+		return dflt, nil
+	}
+	return pfvAsInt(r, key)
+}
+
 func pfvAsString(r *http.Request, key string) (string, error) {
 	raw := r.PostFormValue(key)
 	if raw == "" {
@@ -183,3 +364,72 @@ func pfvAsUint(r *http.Request, key string) (uint64, error) {
 	}
 	return val, nil
 }
+
+// qpvAsInt returns the value of a query parameter, or the default if it is missing.
+func qpvAsInt(r *http.Request, key string, dflt, min, max int) (int, error) {
+	values := r.URL.Query()[key]
+	if len(values) == 0 {
+		return dflt, nil
+	} else if len(values) > 1 {
+		return 0, fmt.Errorf("%q: repeated", key)
+	}
+	val, err := strconv.Atoi(values[0])
+	if err != nil {
+		return 0, fmt.Errorf("%q: %w", key, err)
+	} else if val < min || val > max {
+		return 0, fmt.Errorf("%q: out of range", key)
+	}
+	return val, nil
+}
+
+// qpvAsElevationRange returns the range of elevations, in meters, from the
+// "minElevation" and "maxElevation" query parameters.
+func qpvAsElevationRange(r *http.Request) (gen.ElevationRange, error) {
+	er := gen.DefaultElevationRange()
+	lo, err := qpvAsInt(r, "minElevation", int(er.Min), -100_000, 100_000)
+	if err != nil {
+		return er, err
+	}
+	hi, err := qpvAsInt(r, "maxElevation", int(er.Max), -100_000, 100_000)
+	if err != nil {
+		return er, err
+	}
+	er = gen.ElevationRange{Min: float64(lo), Max: float64(hi)}
+	return er, er.Validate()
+}
+
+// qpvAsPalette returns the palette named by the "palette" query parameter, or the default palette.
+func qpvAsPalette(r *http.Request, pals palettes) (*cmap.Palette, error) {
+	name, err := qpvAsPaletteName(r, pals)
+	if err != nil {
+		return nil, err
+	}
+	return pals[name], nil
+}
+
+// qpvAsPaletteName returns the key of the palette named by the "palette"
+// query parameter, or "default". The key is the name of the palette's file,
+// which isn't always the name inside it.
+func qpvAsPaletteName(r *http.Request, pals palettes) (string, error) {
+	name, err := qpvAsString(r, "palette", "default")
+	if err != nil {
+		return "", err
+	} else if name == "" {
+		name = "default"
+	}
+	if _, err = pals.get(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// qpvAsString returns the value of a query parameter, or the default if it is missing.
+func qpvAsString(r *http.Request, key string, dflt string) (string, error) {
+	values := r.URL.Query()[key]
+	if len(values) == 0 {
+		return dflt, nil
+	} else if len(values) > 1 {
+		return "", fmt.Errorf("%q: repeated", key)
+	}
+	return values[0], nil
+}
@@ -19,12 +19,22 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/mdhender/worldgen/pkg/auth"
 	"github.com/mdhender/worldgen/pkg/cmap"
+	"github.com/mdhender/worldgen/pkg/contour"
+	"github.com/mdhender/worldgen/pkg/decor"
 	"github.com/mdhender/worldgen/pkg/gen"
+	"github.com/mdhender/worldgen/pkg/geojson"
+	"github.com/mdhender/worldgen/pkg/mesh"
+	"github.com/mdhender/worldgen/pkg/names"
+	"github.com/mdhender/worldgen/pkg/tiles"
 	"github.com/mdhender/worldgen/pkg/way"
 	"html/template"
+	"image"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -34,7 +44,7 @@ import (
 	"time"
 )
 
-func indexHandler(root string) http.HandlerFunc {
+func indexHandler(a *authenticator, root string, pals palettes) http.HandlerFunc {
 	root = filepath.Clean(root)
 	rr := Renderer{}
 	for _, tmpl := range []string{"layout", "index"} {
@@ -43,12 +53,18 @@ func indexHandler(root string) http.HandlerFunc {
 	log.Printf("index: %v\n", rr.files)
 
 	type Data struct {
-		SecretRequired bool
+		AuthEnabled bool
+		User        string // empty if anonymous
+		CanGenerate bool
+		Palettes    []string
 	}
-	secretRequired := os.Getenv("WMG_SECRET") != ""
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		data := Data{SecretRequired: secretRequired}
+		u, _ := a.user(r)
+		data := Data{AuthEnabled: a.enabled(), CanGenerate: u.Can(auth.RoleGenerate), Palettes: pals.names()}
+		if u != nil {
+			data.User = u.Name
+		}
 		rr.Render(w, r, data)
 	}
 }
@@ -295,8 +311,72 @@ func cartoHandler() http.HandlerFunc {
 	}
 }
 
-func customizeHandler(root, filename string) http.HandlerFunc {
-	templ := template.Must(template.ParseFiles(filepath.Join(root, filename)))
+// customizeHandler returns a page with sliders that re-render a cached map
+// as they move. The settings are kept in the page's query, so the URL can
+// be shared to show the same view.
+func customizeHandler(root string, pals palettes) http.HandlerFunc {
+	root = filepath.Clean(root)
+	rr := Renderer{}
+	for _, tmpl := range []string{"layout", "customize"} {
+		rr.files = append(rr.files, filepath.Join(root, tmpl+".gohtml"))
+	}
+	log.Printf("customize: %v\n", rr.files)
+
+	type Data struct {
+		Name               string
+		Height, Width      int
+		Seed               string
+		Histogram          [256]int
+		ShiftX, ShiftY     int
+		PctWater, SeaLevel int
+		PctIce, IceLevel   int
+		Palette            string
+		Palettes           []string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := way.Param(r.Context(), "name")
+		var err error
+		var input struct {
+			pctWater, pctIce int
+			shiftX, shiftY   int
+			palette          string
+		}
+		if input.pctWater, err = qpvAsInt(r, "pctWater", 55, 0, 100); err != nil {
+		} else if input.pctIce, err = qpvAsInt(r, "pctIce", 8, 0, 100); err != nil {
+		} else if input.shiftX, err = qpvAsInt(r, "shiftX", 0, 0, 100); err != nil {
+		} else if input.shiftY, err = qpvAsInt(r, "shiftY", 0, 0, 100); err != nil {
+		} else if input.palette, err = qpvAsPaletteName(r, pals); err != nil {
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+
+		m, ok := loadNamedMap(w, r)
+		if !ok {
+			return
+		}
+
+		rr.Render(w, r, Data{
+			Name:      name,
+			Height:    m.Height(),
+			Width:     m.Width(),
+			Seed:      m.Metadata().Seed,
+			Histogram: m.Histogram(),
+			ShiftX:    input.shiftX,
+			ShiftY:    input.shiftY,
+			PctWater:  input.pctWater,
+			SeaLevel:  m.SeaLevel(input.pctWater),
+			PctIce:    input.pctIce,
+			IceLevel:  m.IceLevel(input.pctIce),
+			Palette:   input.palette,
+			Palettes:  pals.names(),
+		})
+	}
+}
+
+func greyscaleHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var seed uint64
 
@@ -317,144 +397,647 @@ func customizeHandler(root, filename string) http.HandlerFunc {
 			return
 		}
 
-		// get shiftX and shiftY from query parameters
-		var shiftX, shiftY int
-		if qShiftX := r.URL.Query()["shiftX"]; len(qShiftX) == 0 {
-			shiftX = 0
-		} else if len(qShiftX) > 1 {
-			http.Error(w, "shiftX repeated", http.StatusBadRequest)
+		var m gen.Map
+		if data, err := os.ReadFile(fmt.Sprintf("%x-asteroids.json", seed)); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
-		} else if shiftX, err = strconv.Atoi(qShiftX[0]); err != nil {
-			http.Error(w, fmt.Sprintf("shiftX %v", err), http.StatusBadRequest)
+		} else if err = json.Unmarshal(data, &m); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
-		} else if shiftX < 0 || shiftX > 99 {
-			http.Error(w, "shiftX out of range", http.StatusBadRequest)
+		}
+
+		png, err := m.AsPNG(m.AsGreyscale())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
 			return
-		} else {
-			log.Printf("shiftX %v %d\n", qShiftX, shiftX)
 		}
-		if qShiftY := r.URL.Query()["shiftY"]; len(qShiftY) == 0 {
-			shiftY = 0
-		} else if len(qShiftY) > 1 {
-			http.Error(w, "shiftY repeated", http.StatusBadRequest)
+
+		w.Header().Set("Content-Type", "image/png")
+		w.WriteHeader(http.StatusOK)
+		w.Write(png)
+	}
+}
+
+// exportHandler returns a cached map as JSON for tools that can't read the binary format.
+func exportHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := way.Param(r.Context(), "name")
+		m, ok := loadNamedMap(w, r)
+		if !ok {
 			return
-		} else if shiftY, err = strconv.Atoi(qShiftY[0]); err != nil {
-			http.Error(w, fmt.Sprintf("shiftY %v", err), http.StatusBadRequest)
+		}
+
+		data, err := json.Marshal(m)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
 			return
-		} else if shiftY < 0 || shiftY > 99 {
-			http.Error(w, "shiftY out of range", http.StatusBadRequest)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".json"))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+	}
+}
+
+// loadNamedMap loads the cached map named in the route. If the name is
+// invalid or the map can't be loaded, it writes the error and returns false.
+func loadNamedMap(w http.ResponseWriter, r *http.Request) (*gen.Map, bool) {
+	name := way.Param(r.Context(), "name")
+	if !isMapName(name) {
+		http.Error(w, "invalid map name", http.StatusBadRequest)
+		return nil, false
+	}
+	m, err := loadMap(name)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+	return m, true
+}
+
+// isMapName returns true if the name looks like one created by mapName.
+// This keeps requests from reaching outside the cache.
+func isMapName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, ch := range name {
+		if !(('0' <= ch && ch <= '9') || ('a' <= ch && ch <= 'f') || ch == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// heightmapHandler returns the un-quantized heights of a cached map.
+// The format query parameter selects a 16-bit PNG (png16, the default),
+// raw little-endian uint16 (r16), raw little-endian float32 (f32),
+// or a float32 GeoTIFF (tif).
+func heightmapHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := way.Param(r.Context(), "name")
+		format := "png16"
+		if qFormat := r.URL.Query()["format"]; len(qFormat) > 1 {
+			http.Error(w, "format repeated", http.StatusBadRequest)
 			return
-		} else {
-			log.Printf("shiftY %v %d\n", qShiftY, shiftY)
+		} else if len(qFormat) == 1 {
+			format = qFormat[0]
 		}
 
-		// get pctWater from query parameters
-		var pctWater int
-		if qPctWater := r.URL.Query()["pctWater"]; len(qPctWater) == 0 {
-			pctWater = 50 // default value
-		} else if len(qPctWater) > 1 {
-			http.Error(w, "pctWater repeated", http.StatusBadRequest)
+		m, ok := loadNamedMap(w, r)
+		if !ok {
 			return
-		} else if pctWater, err = strconv.Atoi(qPctWater[0]); err != nil {
+		}
+
+		var err error
+		bb := &bytes.Buffer{}
+		contentType, ext := "application/octet-stream", format
+		switch format {
+		case "png16":
+			var data []byte
+			if data, err = m.AsPNG(m.AsGreyscale16()); err == nil {
+				bb.Write(data)
+			}
+			contentType, ext = "image/png", "png"
+		case "r16":
+			err = m.WriteRawUint16(bb)
+		case "f32":
+			err = m.WriteRawFloat32(bb)
+		case "tif":
+			err = m.WriteGeoTIFF(bb)
+			contentType = "image/tiff"
+		default:
+			http.Error(w, "format must be png16, r16, f32, or tif", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+ext))
+		w.Header().Set("WG-Height", strconv.Itoa(m.Height()))
+		w.Header().Set("WG-Width", strconv.Itoa(m.Width()))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(bb.Bytes())
+	}
+}
+
+// meshHandler returns a cached map as a 3D mesh.
+// The obj and stl formats are a relief of a region of the map, with a base
+// so that it can be printed. The gltf format is a globe with the map colors.
+func meshHandler(pals palettes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := way.Param(r.Context(), "name")
+		var err error
+		var input struct {
+			format           string
+			step             int
+			scale            int // percent of the width of the region
+			x, y, w, h       int
+			pctWater, pctIce int
+			palette          *cmap.Palette
+		}
+		if input.format, err = qpvAsString(r, "format", "gltf"); err != nil {
+		} else if input.step, err = qpvAsInt(r, "step", 4, 1, 100); err != nil {
+		} else if input.scale, err = qpvAsInt(r, "scale", 5, 0, 100); err != nil {
+		} else if input.x, err = qpvAsInt(r, "x", 0, 0, math.MaxInt32); err != nil {
+		} else if input.y, err = qpvAsInt(r, "y", 0, 0, math.MaxInt32); err != nil {
+		} else if input.w, err = qpvAsInt(r, "w", 0, 0, math.MaxInt32); err != nil {
+		} else if input.h, err = qpvAsInt(r, "h", 0, 0, math.MaxInt32); err != nil {
+		} else if input.pctWater, err = qpvAsInt(r, "pctWater", 55, 0, 100); err != nil {
+		} else if input.pctIce, err = qpvAsInt(r, "pctIce", 8, 0, 100); err != nil {
+		} else if input.palette, err = qpvAsPalette(r, pals); err != nil {
+		}
+		if err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
-		} else if pctWater < 1 || pctWater > 99 {
-			http.Error(w, "pctWater out of range", http.StatusBadRequest)
+		}
+
+		m, ok := loadNamedMap(w, r)
+		if !ok {
 			return
 		}
 
-		// get pctIce from query parameters
-		var pctIce int
-		if qPctWater := r.URL.Query()["pctIce"]; len(qPctWater) == 0 {
-			pctIce = 50 // default value
-		} else if len(qPctWater) > 1 {
-			http.Error(w, "pctIce repeated", http.StatusBadRequest)
+		region := image.Rect(input.x, input.y, input.x+input.w, input.y+input.h)
+		if input.w == 0 || input.h == 0 {
+			region = image.Rect(0, 0, m.Width(), m.Height())
+		}
+		relief := mesh.ReliefOptions{
+			Region: region,
+			Step:   input.step,
+			Scale:  float64(region.Dx()*input.scale) / 100,
+			Base:   float64(region.Dx()) / 50,
+		}
+
+		bb := &bytes.Buffer{}
+		contentType := "application/octet-stream"
+		switch input.format {
+		case "obj", "stl":
+			var mm *mesh.Mesh
+			if mm, err = mesh.Relief(m.Heights(), m.Width(), m.Height(), relief); err != nil {
+				http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+				return
+			} else if input.format == "obj" {
+				contentType = "model/obj"
+				err = mm.WriteOBJ(bb)
+			} else {
+				contentType = "model/stl"
+				err = mm.WriteSTL(bb)
+			}
+		case "gltf":
+			var mm *mesh.Mesh
+			var texture []byte
+			cm := input.palette.ColorMap(m.Histogram(), input.pctWater, input.pctIce)
+			if mm, err = mesh.Sphere(m.Heights(), m.Width(), m.Height(), mesh.SphereOptions{Step: input.step, Radius: 1, Displacement: float64(input.scale) / 100}); err != nil {
+			} else if texture, err = m.AsPNG(m.AsCarto(cm)); err != nil {
+			} else {
+				contentType = "model/gltf+json"
+				err = mm.WriteGLTF(bb, texture)
+			}
+		default:
+			http.Error(w, "format must be obj, stl, or gltf", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
 			return
-		} else if pctIce, err = strconv.Atoi(qPctWater[0]); err != nil {
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+input.format))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(bb.Bytes())
+	}
+}
+
+// contoursHandler returns the contour lines and coastline of a cached map as SVG.
+// Every fifth contour is an index contour and is drawn heavier.
+func contoursHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		var input struct {
+			interval int
+			pctWater int
+		}
+		if input.interval, err = qpvAsInt(r, "interval", 16, 1, 255); err != nil {
+		} else if input.pctWater, err = qpvAsInt(r, "pctWater", 55, 0, 100); err != nil {
+		}
+		if err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
-		} else if pctIce < 1 || pctIce > 99 {
-			http.Error(w, "pctIce out of range", http.StatusBadRequest)
+		}
+
+		m, ok := loadNamedMap(w, r)
+		if !ok {
 			return
 		}
 
-		var m gen.Map
-		if data, err := os.ReadFile(fmt.Sprintf("%x-asteroids.json", seed)); err != nil {
+		contours := contour.Layer{Name: "contours", Stroke: "#b08050", StrokeWidth: 0.5}
+		index := contour.Layer{Name: "index-contours", Stroke: "#8a5a2b", StrokeWidth: 1}
+		for _, line := range m.Contours(float64(input.interval)) {
+			if int(line.Level)%(5*input.interval) == 0 {
+				index.Lines = append(index.Lines, line)
+			} else {
+				contours.Lines = append(contours.Lines, line)
+			}
+		}
+		coastline := contour.Layer{Name: "coastline", Stroke: "#1f4e9c", StrokeWidth: 1.5, Lines: m.Coastline(input.pctWater)}
+
+		bb := &bytes.Buffer{}
+		if err = contour.WriteSVG(bb, m.Width(), m.Height(), "white", []contour.Layer{contours, index, coastline}); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(bb.Bytes())
+	}
+}
+
+func geojsonHandler() http.HandlerFunc {
+	validLayers := map[string]bool{"land": true, "water": true, "ice": true, "coastline": true}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		var input struct {
+			pctWater int
+			pctIce   int
+			layers   string
+		}
+		if input.pctWater, err = qpvAsInt(r, "pctWater", 55, 0, 100); err != nil {
+		} else if input.pctIce, err = qpvAsInt(r, "pctIce", 8, 0, 100); err != nil {
+		} else if input.layers, err = qpvAsString(r, "layers", "land,water,ice,coastline"); err != nil {
+		}
+		if err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
-		} else if err = json.Unmarshal(data, &m); err != nil {
+		}
+		layers := strings.Split(input.layers, ",")
+		for _, layer := range layers {
+			if !validLayers[layer] {
+				http.Error(w, fmt.Sprintf("layers: invalid layer %q", layer), http.StatusBadRequest)
+				return
+			}
+		}
+
+		m, ok := loadNamedMap(w, r)
+		if !ok {
+			return
+		}
+
+		data, err := json.Marshal(m.AsGeoJSON(input.pctWater, input.pctIce, layers...))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/geo+json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+	}
+}
+
+// statsHandler returns the named regions, mountain ranges and peaks of a cached map as JSON.
+func statsHandler(cs corpora) http.HandlerFunc {
+	type regionStats struct {
+		ID           int              `json:"id"`
+		Kind         gen.RegionKind   `json:"kind"`
+		Name         string           `json:"name"`
+		Label        string           `json:"label"`
+		AreaPct      float64          `json:"area_pct"`
+		AreaKm2      float64          `json:"area_km2"`
+		MaxElevation int              `json:"max_elevation"`
+		Center       geojson.Position `json:"center"`
+	}
+	type peakStats struct {
+		Name      string           `json:"name"`
+		Elevation float64          `json:"elevation_m"`
+		At        geojson.Position `json:"at"`
+	}
+	type stats struct {
+		Name       string        `json:"name"`
+		Seed       string        `json:"seed,omitempty"`
+		Width      int           `json:"width"`
+		Height     int           `json:"height"`
+		Iterations int           `json:"iterations"`
+		SeaLevel   int           `json:"sea_level"`
+		IceLevel   int           `json:"ice_level"`
+		Regions    []regionStats `json:"regions"`
+		Ranges     []regionStats `json:"ranges"`
+		Peaks      []peakStats   `json:"peaks"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := way.Param(r.Context(), "name")
+		var err error
+		var input struct {
+			pctWater, pctIce int
+			elevations       gen.ElevationRange
+			names            *names.Generator
+		}
+		if input.pctWater, err = qpvAsInt(r, "pctWater", 55, 0, 100); err != nil {
+		} else if input.pctIce, err = qpvAsInt(r, "pctIce", 8, 0, 100); err != nil {
+		} else if input.elevations, err = qpvAsElevationRange(r); err != nil {
+		} else if input.names, err = qpvAsCorpus(r, cs); err != nil {
+		}
+		if err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
 		}
 
-		data := struct {
-			Height, Width      int
-			Seed               string
-			Histogram          [256]string
-			ShiftX, ShiftY     int
-			PctWater, SeaLevel int
-			PctIce, IceLevel   int
-		}{
-			Height:   m.Height(),
-			Width:    m.Width(),
-			Seed:     fmt.Sprintf("%x", seed),
-			ShiftX:   shiftX,
-			ShiftY:   shiftY,
-			PctWater: pctWater,
-			SeaLevel: m.SeaLevel(pctWater),
-			PctIce:   pctIce,
-			IceLevel: m.IceLevel(pctIce),
+		m, ok := loadNamedMap(w, r)
+		if !ok {
+			return
+		}
+
+		rs := m.Regions(input.pctWater, input.pctIce)
+		peaks, ranges := nameWorld(m, rs, input.names, input.elevations)
+		lonLat := func(p image.Point) geojson.Position {
+			return geojson.LonLat(float64(p.X)+0.5, float64(p.Y)+0.5, m.Width(), m.Height())
+		}
+
+		out := stats{
+			Name:       name,
+			Seed:       m.Metadata().Seed,
+			Width:      m.Width(),
+			Height:     m.Height(),
+			Iterations: m.Iterations(),
+			SeaLevel:   rs.SeaLevel,
+			IceLevel:   rs.IceLevel,
+			Regions:    []regionStats{},
+			Ranges:     []regionStats{},
+			Peaks:      []peakStats{},
+		}
+		surface := 4 * math.Pi * gen.EarthRadius * gen.EarthRadius
+		regionStatsOf := func(rg *gen.Region) regionStats {
+			return regionStats{
+				ID:           rg.ID,
+				Kind:         rg.Kind,
+				Name:         rg.Name,
+				Label:        rg.Label,
+				AreaPct:      math.Round(rg.Area*1e6) / 1e4,
+				AreaKm2:      math.Round(rg.Area * surface),
+				MaxElevation: rg.MaxElevation,
+				Center:       lonLat(rg.Center),
+			}
 		}
-		pixels, count := m.Height()*m.Width(), 0
-		for n, val := range m.Histogram() {
-			count += val
-			data.Histogram[n] = fmt.Sprintf("%03d: %8d / %8d / %8d\n", n, val, count, pixels)
+		for _, rg := range rs.List {
+			out.Regions = append(out.Regions, regionStatsOf(rg))
+		}
+		for _, rg := range ranges {
+			out.Ranges = append(out.Ranges, regionStatsOf(rg))
+		}
+		for _, p := range peaks {
+			out.Peaks = append(out.Peaks, peakStats{Name: p.Name, Elevation: math.Round(p.Elevation), At: lonLat(p.At)})
 		}
 
-		bb := &bytes.Buffer{}
-		if err = templ.Execute(bb, data); err != nil {
-			log.Printf("%s %q %v\n", r.Method, r.URL, err)
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
 			return
 		}
 
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		w.Write(bb.Bytes())
+		_, _ = w.Write(data)
 	}
 }
 
-func greyscaleHandler() http.HandlerFunc {
+// shadedHandler returns a shaded-relief image of a cached map.
+// The shading is multiplied over the colors, or returned as greyscale if colors is "none."
+func shadedHandler(pals palettes) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var seed uint64
+		var err error
+		var input struct {
+			mode             string
+			colors           string
+			azimuth          int
+			altitude         int
+			exaggeration     int
+			strength         int // percent
+			pctWater, pctIce int
+			palette          *cmap.Palette
+			elevations       gen.ElevationRange
+		}
+		if input.mode, err = qpvAsString(r, "mode", "hillshade"); err != nil {
+		} else if input.colors, err = qpvAsString(r, "colors", "carto"); err != nil {
+		} else if input.azimuth, err = qpvAsInt(r, "azimuth", 315, 0, 360); err != nil {
+		} else if input.altitude, err = qpvAsInt(r, "altitude", 45, 1, 90); err != nil {
+		} else if input.exaggeration, err = qpvAsInt(r, "exaggeration", 20, 1, 1000); err != nil {
+		} else if input.strength, err = qpvAsInt(r, "strength", 60, 0, 100); err != nil {
+		} else if input.pctWater, err = qpvAsInt(r, "pctWater", 55, 0, 100); err != nil {
+		} else if input.pctIce, err = qpvAsInt(r, "pctIce", 8, 0, 100); err != nil {
+		} else if input.palette, err = qpvAsPalette(r, pals); err != nil {
+		} else if input.elevations, err = qpvAsElevationRange(r); err != nil {
+		} else if input.mode != "hillshade" && input.mode != "slope" {
+			err = fmt.Errorf("%q: invalid mode", "mode")
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
 
-		started := time.Now()
-		defer func() {
-			log.Printf("%s %q elapsed %v\n", r.Method, r.URL, time.Now().Sub(started))
-		}()
+		m, ok := loadNamedMap(w, r)
+		if !ok {
+			return
+		}
 
-		pSeed := way.Param(r.Context(), "seed")
-		if pSeed == "" {
-			http.Error(w, "missing seed", http.StatusBadRequest)
+		opts := gen.ShadeOptions{
+			Azimuth:      float64(input.azimuth),
+			Altitude:     float64(input.altitude),
+			Exaggeration: float64(input.exaggeration),
+			Slope:        input.mode == "slope",
+			Strength:     float64(input.strength) / 100,
+		}
+		var img image.Image
+		switch input.colors {
+		case "carto":
+			cm := input.palette.ColorMap(m.Histogram(), input.pctWater, input.pctIce)
+			img = m.AsShaded(m.AsCarto(cm), opts)
+		case "elevation":
+			img = m.AsShaded(m.AsElevationMap(input.elevations, input.palette.Elevations), opts)
+		case "greyscale":
+			img = m.AsShaded(m.AsGreyscale(), opts)
+		case "image":
+			img = m.AsShaded(m.AsImage(), opts)
+		case "none":
+			img = m.AsHillshade(opts)
+		default:
+			http.Error(w, fmt.Sprintf("%q: invalid colors", "colors"), http.StatusBadRequest)
 			return
 		}
+
+		png, err := m.AsPNG(img)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(png)
+	}
+}
+
+// textureHandler returns the textures used by 3D renderers for a cached map.
+func textureHandler(pals palettes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		var err error
-		seed, err = strconv.ParseUint(pSeed, 16, 64)
+		var input struct {
+			kind             string
+			exaggeration     int
+			pctWater, pctIce int
+			palette          *cmap.Palette
+		}
+		if input.kind, err = qpvAsString(r, "kind", "normal"); err != nil {
+		} else if input.exaggeration, err = qpvAsInt(r, "exaggeration", 20, 1, 1000); err != nil {
+		} else if input.pctWater, err = qpvAsInt(r, "pctWater", 55, 0, 100); err != nil {
+		} else if input.pctIce, err = qpvAsInt(r, "pctIce", 8, 0, 100); err != nil {
+		} else if input.palette, err = qpvAsPalette(r, pals); err != nil {
+		}
 		if err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
 		}
 
-		var m gen.Map
-		if data, err := os.ReadFile(fmt.Sprintf("%x-asteroids.json", seed)); err != nil {
+		m, ok := loadNamedMap(w, r)
+		if !ok {
+			return
+		}
+
+		var img image.Image
+		switch input.kind {
+		case "color":
+			img = m.AsCarto(input.palette.ColorMap(m.Histogram(), input.pctWater, input.pctIce))
+		case "normal":
+			img = m.AsNormalMap(float64(input.exaggeration))
+		case "water":
+			img = m.AsWaterMask(input.pctWater)
+		case "roughness":
+			img = m.AsRoughnessMap(input.pctWater, input.pctIce, float64(input.exaggeration))
+		default:
+			http.Error(w, fmt.Sprintf("%q: invalid kind", "kind"), http.StatusBadRequest)
+			return
+		}
+
+		png, err := m.AsPNG(img)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(png)
+	}
+}
+
+// renderHandler returns a cached map colored like the one from the web form.
+// The width query parameter scales it down for thumbnails.
+func renderHandler(pals palettes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		var input struct {
+			pctWater, pctIce int
+			shiftX, shiftY   int
+			width            int // scale the image to this width, 0 for full size
+			palette          *cmap.Palette
+		}
+		if input.pctWater, err = qpvAsInt(r, "pctWater", 55, 0, 100); err != nil {
+		} else if input.pctIce, err = qpvAsInt(r, "pctIce", 8, 0, 100); err != nil {
+		} else if input.shiftX, err = qpvAsInt(r, "shiftX", 0, 0, 100); err != nil {
+		} else if input.shiftY, err = qpvAsInt(r, "shiftY", 0, 0, 100); err != nil {
+		} else if input.width, err = qpvAsInt(r, "width", 0, 0, 4096); err != nil {
+		} else if input.palette, err = qpvAsPalette(r, pals); err != nil {
+		}
+		if err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
-		} else if err = json.Unmarshal(data, &m); err != nil {
+		}
+
+		m, ok := loadNamedMap(w, r)
+		if !ok {
+			return
+		}
+
+		writeCarto(w, m, cartoOptions{
+			pctWater: input.pctWater,
+			pctIce:   input.pctIce,
+			shiftX:   input.shiftX,
+			shiftY:   input.shiftY,
+			width:    input.width,
+			palette:  input.palette,
+		})
+	}
+}
+
+// cartoOptions are the settings for rendering a map with a palette.
+type cartoOptions struct {
+	pctWater, pctIce int
+	shiftX, shiftY   int // percent of the width and height
+	width            int // scale the image to this width, 0 for full size
+	palette          *cmap.Palette
+}
+
+// writeCarto renders the map and writes it as a PNG.
+// Shifting changes the map, so it shouldn't be shared.
+func writeCarto(w http.ResponseWriter, m *gen.Map, opts cartoOptions) {
+	if opts.shiftX != 0 {
+		m.ShiftX(-1 * m.Width() * opts.shiftX / 100)
+	}
+	if opts.shiftY != 0 {
+		m.ShiftY(m.Height() * opts.shiftY / 100)
+	}
+	cm := opts.palette.ColorMap(m.Histogram(), opts.pctWater, opts.pctIce)
+
+	var img image.Image = m.AsCarto(cm)
+	if opts.width != 0 && opts.width != m.Width() {
+		height := (opts.width*m.Height() + m.Width()/2) / m.Width()
+		if height < 1 {
+			height = 1
+		}
+		img = tiles.Resize(img, opts.width, height)
+	}
+
+	png, err := m.AsPNG(img)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(png)
+}
+
+// elevationHandler returns an image of a cached map colored by elevation in meters.
+func elevationHandler(pals palettes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		var input struct {
+			elevations gen.ElevationRange
+			palette    *cmap.Palette
+		}
+		if input.elevations, err = qpvAsElevationRange(r); err != nil {
+		} else if input.palette, err = qpvAsPalette(r, pals); err != nil {
+		}
+		if err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 			return
 		}
 
-		png, err := m.AsPNG(m.AsGreyscale())
+		m, ok := loadNamedMap(w, r)
+		if !ok {
+			return
+		}
+
+		png, err := m.AsPNG(m.AsElevationMap(input.elevations, input.palette.Elevations))
 		if err != nil {
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
 			return
@@ -462,6 +1045,106 @@ func greyscaleHandler() http.HandlerFunc {
 
 		w.Header().Set("Content-Type", "image/png")
 		w.WriteHeader(http.StatusOK)
-		w.Write(png)
+		_, _ = w.Write(png)
+	}
+}
+
+// atlasHandler returns a cached map decorated for printing, with a graticule,
+// place names, legend, scale bar, compass and title block.
+func atlasHandler(pals palettes, cs corpora) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := way.Param(r.Context(), "name")
+		var err error
+		var input struct {
+			colors           string
+			shaded           int
+			graticule        int
+			legend, compass  int
+			labels           int
+			radius           int
+			title            string
+			scale            int
+			pctWater, pctIce int
+			palette          *cmap.Palette
+			elevations       gen.ElevationRange
+			names            *names.Generator
+		}
+		if input.colors, err = qpvAsString(r, "colors", "carto"); err != nil {
+		} else if input.shaded, err = qpvAsInt(r, "shaded", 1, 0, 1); err != nil {
+		} else if input.graticule, err = qpvAsInt(r, "graticule", 30, 0, 90); err != nil {
+		} else if input.legend, err = qpvAsInt(r, "legend", 1, 0, 1); err != nil {
+		} else if input.compass, err = qpvAsInt(r, "compass", 1, 0, 1); err != nil {
+		} else if input.labels, err = qpvAsInt(r, "labels", 1, 0, 1); err != nil {
+		} else if input.radius, err = qpvAsInt(r, "radius", int(gen.EarthRadius), 0, 1_000_000); err != nil {
+		} else if input.title, err = qpvAsString(r, "title", "World "+name); err != nil {
+		} else if input.scale, err = qpvAsInt(r, "scale", 0, 0, 8); err != nil {
+		} else if input.pctWater, err = qpvAsInt(r, "pctWater", 55, 0, 100); err != nil {
+		} else if input.pctIce, err = qpvAsInt(r, "pctIce", 8, 0, 100); err != nil {
+		} else if input.palette, err = qpvAsPalette(r, pals); err != nil {
+		} else if input.elevations, err = qpvAsElevationRange(r); err != nil {
+		} else if input.names, err = qpvAsCorpus(r, cs); err != nil {
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+
+		m, ok := loadNamedMap(w, r)
+		if !ok {
+			return
+		}
+
+		var img *image.RGBA
+		var legend *decor.Legend
+		switch input.colors {
+		case "carto":
+			cm := input.palette.ColorMap(m.Histogram(), input.pctWater, input.pctIce)
+			img = m.AsCarto(cm)
+			legend = decor.ColorMapLegend(cm, m.SeaLevel(input.pctWater), m.IceLevel(input.pctIce))
+		case "elevation":
+			img = m.AsElevationMap(input.elevations, input.palette.Elevations)
+			legend = decor.ElevationLegend(input.palette.Elevations, input.elevations.Min, input.elevations.Max)
+		default:
+			http.Error(w, fmt.Sprintf("%q: invalid colors", "colors"), http.StatusBadRequest)
+			return
+		}
+		if input.shaded != 0 {
+			img = m.AsShaded(img, gen.DefaultShadeOptions())
+		}
+
+		md := m.Metadata()
+		opts := decor.Options{
+			Graticule: input.graticule,
+			Radius:    float64(input.radius),
+			Title:     input.title,
+			Subtitle: []string{
+				fmt.Sprintf("seed %s, %d faults", md.Seed, m.Iterations()),
+				fmt.Sprintf("created %s", md.Created.Format("2006-01-02")),
+			},
+			Compass: input.compass != 0,
+			Scale:   input.scale,
+		}
+		if opts.Scale == 0 {
+			opts.Scale = 1 + m.Width()/1000
+		}
+		if input.legend != 0 {
+			opts.Legend = legend
+		}
+		if input.labels != 0 {
+			rs := m.Regions(input.pctWater, input.pctIce)
+			peaks, ranges := nameWorld(m, rs, input.names, input.elevations)
+			opts.Labels = mapLabels(rs, peaks, ranges, opts.Scale)
+		}
+		decor.Decorate(img, opts)
+
+		png, err := m.AsPNG(img)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(png)
 	}
 }
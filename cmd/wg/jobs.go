@@ -0,0 +1,279 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// finished jobs are forgotten after this long
+const jobRetention = time.Hour
+
+var errQueueFull = errors.New("too many jobs waiting, try again later")
+
+type jobState string
+
+const (
+	jobQueued    jobState = "queued"
+	jobRunning   jobState = "running"
+	jobDone      jobState = "done"
+	jobFailed    jobState = "failed"
+	jobCancelled jobState = "cancelled"
+)
+
+// jobStatus is a snapshot of a job.
+type jobStatus struct {
+	ID       string     `json:"id"`
+	Code     string     `json:"code"` // name of the map being created
+	State    jobState   `json:"state"`
+	Progress float64    `json:"progress"` // percent of the faults added
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// finished returns true if the job is done, failed or cancelled.
+func (js jobStatus) finished() bool {
+	return js.State == jobDone || js.State == jobFailed || js.State == jobCancelled
+}
+
+// job is a request to create a map.
+type job struct {
+	seed      uint64
+	addFaults int
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	sync.Mutex
+	status   jobStatus
+	changed  chan struct{} // closed and replaced whenever the status changes
+	waiters  int           // requests that are waiting on the job
+	detached bool          // someone will come back for the result, so don't cancel it
+}
+
+// snapshot returns the current status of the job and a channel
+// that is closed when the status changes.
+func (j *job) snapshot() (jobStatus, <-chan struct{}) {
+	j.Lock()
+	defer j.Unlock()
+	return j.status, j.changed
+}
+
+// update changes the status of the job and wakes up anyone waiting on it.
+func (j *job) update(fn func(*jobStatus)) {
+	j.Lock()
+	defer j.Unlock()
+	fn(&j.status)
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// wait blocks until the job is finished and returns its final status.
+// It returns the context's error if the context is done first.
+func (j *job) wait(ctx context.Context) (jobStatus, error) {
+	for {
+		status, changed := j.snapshot()
+		if status.finished() {
+			return status, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return status, ctx.Err()
+		}
+	}
+}
+
+// attach adds a request to the job. It returns false if the job
+// has already been cancelled.
+func (j *job) attach(wait bool) bool {
+	j.Lock()
+	defer j.Unlock()
+	if j.ctx.Err() != nil {
+		return false
+	}
+	if wait {
+		j.waiters++
+	} else {
+		j.detached = true
+	}
+	return true
+}
+
+// release is called when a waiting request is done with the job.
+// The job is cancelled when nobody is waiting on it any longer,
+// unless it was also submitted by a request that didn't wait.
+func (j *job) release() {
+	j.Lock()
+	defer j.Unlock()
+	if j.waiters--; j.waiters == 0 && !j.detached {
+		j.cancel()
+	}
+}
+
+// jobQueue creates maps with a fixed number of workers, so that a burst
+// of requests can't take over the server. Requests for a map that is
+// already being created share the job that is creating it.
+type jobQueue struct {
+	ws    *worlds
+	queue chan *job
+
+	sync.Mutex
+	jobs   map[string]*job // by id
+	active map[string]*job // queued or running, by map name
+}
+
+// newJobQueue starts the workers. At most depth jobs can be waiting.
+func newJobQueue(ws *worlds, workers, depth int) *jobQueue {
+	q := &jobQueue{
+		ws:     ws,
+		queue:  make(chan *job, depth),
+		jobs:   make(map[string]*job),
+		active: make(map[string]*job),
+	}
+	for n := 0; n < workers; n++ {
+		go q.worker()
+	}
+	return q
+}
+
+// submit queues a job to create the map. If the map is already being
+// created, it returns that job instead of starting another one. Callers
+// that will wait on the job must call release when they are done with it,
+// so that it can be cancelled if they give up.
+func (q *jobQueue) submit(seed uint64, addFaults int, wait bool) (*job, error) {
+	code := q.ws.mapName(seed, addFaults)
+
+	q.Lock()
+	defer q.Unlock()
+	q.prune()
+	// a job that was cancelled by its last waiter stays active until
+	// a worker gets to it, but it will never create the map
+	if j, ok := q.active[code]; ok && j.attach(wait) {
+		return j, nil
+	}
+	j, err := q.newJob(seed, addFaults)
+	if err != nil {
+		return nil, err
+	}
+	j.attach(wait)
+	return j, nil
+}
+
+// newJob queues a new job. The caller must hold the lock.
+func (q *jobQueue) newJob(seed uint64, addFaults int) (*job, error) {
+	code := q.ws.mapName(seed, addFaults)
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	j := &job{
+		seed:      seed,
+		addFaults: addFaults,
+		status: jobStatus{
+			ID:      hex.EncodeToString(id),
+			Code:    code,
+			State:   jobQueued,
+			Created: time.Now().UTC(),
+		},
+		changed: make(chan struct{}),
+	}
+	j.ctx, j.cancel = context.WithCancel(context.Background())
+	select {
+	case q.queue <- j:
+	default:
+		j.cancel()
+		return nil, errQueueFull
+	}
+	q.jobs[j.status.ID] = j
+	q.active[code] = j
+	return j, nil
+}
+
+// get returns the job with the given id, or nil if there isn't one.
+func (q *jobQueue) get(id string) *job {
+	q.Lock()
+	defer q.Unlock()
+	return q.jobs[id]
+}
+
+// prune forgets old jobs. The caller must hold the lock.
+func (q *jobQueue) prune() {
+	for id, j := range q.jobs {
+		if status, _ := j.snapshot(); status.finished() && time.Since(*status.Finished) > jobRetention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+func (q *jobQueue) worker() {
+	for j := range q.queue {
+		q.run(j)
+	}
+}
+
+// run creates the map for the job, reporting progress as it goes.
+func (q *jobQueue) run(j *job) {
+	j.update(func(js *jobStatus) {
+		now := time.Now().UTC()
+		js.State, js.Started = jobRunning, &now
+	})
+	log.Printf("jobs: %s: creating %s\n", j.status.ID, j.status.Code)
+
+	// the clock starts when the job does, not while it waits in the queue
+	ctx, cancel := context.WithTimeout(j.ctx, generateTimeout)
+	_, _, _, err := q.ws.create(ctx, j.seed, j.addFaults, func(done, total int) {
+		j.update(func(js *jobStatus) {
+			js.Progress = math.Round(1000*float64(done)/float64(total)) / 10
+		})
+	})
+
+	cancel()
+	j.cancel()
+
+	q.Lock()
+	if q.active[j.status.Code] == j { // it may have been replaced after it was cancelled
+		delete(q.active, j.status.Code)
+	}
+	q.Unlock()
+	j.update(func(js *jobStatus) {
+		now := time.Now().UTC()
+		js.Finished = &now
+		if errors.Is(err, context.Canceled) {
+			js.State, js.Error = jobCancelled, "cancelled by the client"
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			js.State, js.Error = jobFailed, fmt.Sprintf("took longer than %v", generateTimeout)
+		} else if err != nil {
+			js.State, js.Error = jobFailed, err.Error()
+		} else {
+			js.State, js.Progress = jobDone, 100
+		}
+	})
+	if err != nil {
+		log.Printf("jobs: %s: %v\n", j.status.ID, err)
+	}
+}
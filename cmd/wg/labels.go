@@ -0,0 +1,82 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"github.com/mdhender/worldgen/pkg/decor"
+	"github.com/mdhender/worldgen/pkg/gen"
+	"image/color"
+)
+
+// lakes and islands smaller than this fraction of the globe aren't labeled
+const minLabeledRegion = 0.0005
+
+// mapLabels returns labels for the continents, oceans, larger lakes and
+// islands, the mountain ranges and the peaks of the map. Regions that
+// haven't been named get their generic labels. The generator doesn't make
+// rivers, so there are none to label.
+func mapLabels(rs *gen.Regions, peaks []peak, ranges []*gen.Region, scale int) []decor.Label {
+	water := color.NRGBA{R: 16, G: 48, B: 128, A: 255}
+	land := color.NRGBA{R: 96, G: 56, B: 24, A: 255}
+
+	var labels []decor.Label
+	for _, rg := range rs.List {
+		l := decor.Label{Text: rg.Name, At: rg.Center, Scale: scale}
+		if l.Text == "" {
+			l.Text = rg.Label
+		}
+		switch rg.Kind {
+		case gen.Continent:
+			l.Scale, l.Priority = 2*scale, 4
+		case gen.Ocean:
+			l.Scale, l.Priority, l.Color = 2*scale, 3, water
+		case gen.Lake:
+			if rg.Area < minLabeledRegion {
+				continue
+			}
+			l.Priority, l.Color = 1, water
+		case gen.Island:
+			if rg.Area < minLabeledRegion {
+				continue
+			}
+			l.Priority = 1
+		default:
+			continue
+		}
+		labels = append(labels, l)
+	}
+
+	for _, rg := range ranges {
+		l := decor.Label{Text: rg.Name, At: rg.Center, Scale: scale, Priority: 2, Color: land}
+		if l.Text == "" {
+			l.Text = rg.Label
+		}
+		labels = append(labels, l)
+	}
+
+	for _, p := range peaks {
+		labels = append(labels, decor.Label{
+			Text:     fmt.Sprintf("%s %.0f m", p.Name, p.Elevation),
+			At:       p.At,
+			Scale:    scale,
+			Marker:   true,
+			Priority: 2,
+		})
+	}
+	return labels
+}
@@ -0,0 +1,155 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// limits keeps clients from taking over the server. Creating a map is much
+// more work than rendering a cached one, so the two have separate budgets.
+// Users are limited by name, whichever token or session they use, and
+// anonymous clients by their address.
+type limits struct {
+	a        *authenticator
+	render   *rateLimiter
+	generate *rateLimiter
+}
+
+func newLimits(a *authenticator, render, generate *rateLimiter) *limits {
+	return &limits{a: a, render: render, generate: generate}
+}
+
+// client returns the key for the budget of the client that sent the request.
+func (l *limits) client(r *http.Request) string {
+	if u, err := l.a.user(r); err == nil && u.Name != "" {
+		return "user:" + u.Name
+	}
+	return "ip:" + clientAddress(r)
+}
+
+// clientAddress returns the address that the request came from, without the port.
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// renders only calls h if the client has some of its render budget left.
+func (l *limits) renders(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if l.allowed(w, r, l.render) {
+			h.ServeHTTP(w, r)
+		}
+	}
+}
+
+// generates only calls h if the client has some of its generate budget left.
+func (l *limits) generates(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if l.allowed(w, r, l.generate) {
+			h.ServeHTTP(w, r)
+		}
+	}
+}
+
+// allowed writes an error and returns false if the client has used up the budget.
+func (l *limits) allowed(w http.ResponseWriter, r *http.Request, rl *rateLimiter) bool {
+	ok, wait := rl.allow(l.client(r))
+	if !ok {
+		tooManyRequests(w, wait)
+	}
+	return ok
+}
+
+// tooManyRequests tells the client how long to wait before trying again.
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	seconds := retryAfter(wait)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, fmt.Sprintf("too many requests, try again in %d seconds", seconds), http.StatusTooManyRequests)
+}
+
+// retryAfter rounds the wait up to whole seconds.
+func retryAfter(wait time.Duration) int {
+	return int(math.Ceil(wait.Seconds()))
+}
+
+// rateLimiter is a set of token buckets, one per client. Each bucket holds
+// up to burst tokens and is refilled at rate tokens per second. Every
+// request takes a token, so a client can make burst requests at once and
+// then rate requests per second.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket), pruned: time.Now()}
+}
+
+// allow takes a token from the client's bucket. If the bucket is empty,
+// it returns false and how long until there will be a token.
+func (rl *rateLimiter) allow(client string) (bool, time.Duration) {
+	rl.Lock()
+	defer rl.Unlock()
+
+	now := time.Now()
+	if now.Sub(rl.pruned) > time.Minute {
+		rl.prune(now)
+	}
+
+	b, ok := rl.buckets[client]
+	if !ok {
+		b = &bucket{tokens: rl.burst, updated: now}
+		rl.buckets[client] = b
+	}
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.updated).Seconds()*rl.rate)
+	b.updated = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune forgets the buckets that have filled up again, since they are
+// the same as new ones. The caller must hold the lock.
+func (rl *rateLimiter) prune(now time.Time) {
+	for client, b := range rl.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, client)
+		}
+	}
+	rl.pruned = now
+}
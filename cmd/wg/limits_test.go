@@ -0,0 +1,59 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	rl := newRateLimiter(1, 3)
+	for n := 0; n < 3; n++ {
+		if ok, _ := rl.allow("a"); !ok {
+			t.Fatalf("request %d: want allowed within the burst", n+1)
+		}
+	}
+	ok, wait := rl.allow("a")
+	if ok {
+		t.Fatal("request 4: want refused after the burst")
+	} else if wait <= 0 || wait > time.Second {
+		t.Fatalf("request 4: want a wait of up to a second, got %v", wait)
+	}
+
+	// clients have their own buckets
+	if ok, _ = rl.allow("b"); !ok {
+		t.Fatal("b: want allowed")
+	}
+
+	// the bucket refills at the rate
+	rl.buckets["a"].updated = rl.buckets["a"].updated.Add(-1500 * time.Millisecond)
+	if ok, _ = rl.allow("a"); !ok {
+		t.Fatal("after refilling: want allowed")
+	} else if ok, _ = rl.allow("a"); ok {
+		t.Fatal("after refilling: want only one token")
+	}
+
+	// full buckets are forgotten
+	rl.buckets["b"].updated = rl.buckets["b"].updated.Add(-time.Minute)
+	rl.prune(time.Now())
+	if _, ok = rl.buckets["b"]; ok {
+		t.Error("b: want pruned")
+	} else if _, ok = rl.buckets["a"]; !ok {
+		t.Error("a: want kept")
+	}
+}
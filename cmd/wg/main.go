@@ -19,8 +19,12 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/mdhender/worldgen/pkg/auth"
 	"github.com/mdhender/worldgen/pkg/gen"
 	"github.com/mdhender/worldgen/pkg/generator"
 	"github.com/mdhender/worldgen/pkg/sliced"
@@ -42,37 +46,148 @@ func main() {
 	Seed := 0x638bb317ac47a6ba
 	rand.Seed(int64(Seed))
 
-	height, width, iterations := 600, 1_200, 10_000
+	cfg, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if err = cfg.chdir(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("data: %s\n", cfg.DataDir)
 
-	templates := filepath.Join("..", "templates")
-	public := filepath.Join("..", "public")
+	templates := cfg.Templates
+	public := cfg.Public
 	css := filepath.Join(public, "css")
 
+	pals, err := loadPalettes(cfg.Palettes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("palettes: %v\n", pals.names())
+
+	cs, err := loadCorpora(cfg.Names)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	a, err := newAuthenticator(cfg.Users, os.Getenv("WMG_SECRET"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// rendering allows bursts for the tiles of the viewer and the thumbnails
+	// of the gallery, creating a map allows a few and then one every 30 seconds.
+	lim := newLimits(a, newRateLimiter(10, 120), newRateLimiter(1.0/30, 3))
+	ws := newWorlds(cfg.Height, cfg.Width, cfg.Iterations, cfg.MaxIterations)
+	jobs := newJobQueue(ws, cfg.Workers, cfg.QueueDepth)
+	g := newGallery()
+	tb := newTileBases(128 << 20)
+	rc, err := newRenderCache("render-cache", 64<<20, 1<<30, gen.Version())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// viewing cached maps needs the render role and creating or deleting maps
+	// needs the generate role. The render routes check the role and the rate
+	// limit before the cache, so that cached responses are protected too.
+	render := func(h http.Handler) http.HandlerFunc {
+		return a.require(auth.RoleRender, lim.renders(rc.handler(h)))
+	}
+
 	router := way.NewRouter()
 
-	router.Handle("GET", "/", indexHandler(templates))
+	router.Handle("GET", "/", a.page(auth.RoleRender, indexHandler(a, templates, pals)))
 	router.Handle("GET", "/css...", staticHandler(css, "/css"))
+	router.Handle("GET", "/js...", staticHandler(filepath.Join(public, "js"), "/js"))
 	router.Handle("GET", "/favicon.ico", staticFileHandler(public, "favicon.ico"))
-	router.Handle("POST", "/generate", generateHandler(height, width, iterations))
+	router.Handle("GET", "/login", a.loginHandler(templates))
+	router.Handle("POST", "/login", a.loginHandler(templates))
+	router.Handle("POST", "/logout", a.logoutHandler())
+	router.Handle("POST", "/generate", a.require(auth.RoleRender, lim.renders(generateHandler(a, lim, ws, jobs, rc, pals))))
+	router.Handle("GET", "/gallery", a.page(auth.RoleRender, g.handler(a, templates)))
+	router.Handle("POST", "/delete/:name", a.require(auth.RoleGenerate, g.deleteHandler(rc.forget, tb.forget)))
+	router.Handle("GET", "/export/:name", render(exportHandler()))
+	router.Handle("GET", "/heightmap/:name", render(heightmapHandler()))
+	router.Handle("GET", "/mesh/:name", render(meshHandler(pals)))
+	router.Handle("GET", "/contours/:name", render(contoursHandler()))
+	router.Handle("GET", "/geojson/:name", render(geojsonHandler()))
+	router.Handle("GET", "/shaded/:name", render(shadedHandler(pals)))
+	router.Handle("GET", "/texture/:name", render(textureHandler(pals)))
+	router.Handle("GET", "/elevation/:name", render(elevationHandler(pals)))
+	router.Handle("GET", "/atlas/:name", render(atlasHandler(pals, cs)))
+	router.Handle("GET", "/stats/:name", render(statsHandler(cs)))
+	router.Handle("GET", "/render/:name", render(renderHandler(pals)))
+	router.Handle("GET", "/carto/:name", render(renderHandler(pals)))
+	router.Handle("GET", "/customize/:name", a.page(auth.RoleRender, customizeHandler(templates, pals)))
+	router.Handle("GET", "/tiles/:name/:z/:x/:y", render(tilesHandler(tb, pals)))
+	router.Handle("GET", "/view/:name", a.page(auth.RoleRender, viewHandler(templates, pals)))
+
+	router.Handle("GET", "/api/v1/worlds", apiErrors(a.require(auth.RoleRender, apiListWorldsHandler())))
+	router.Handle("POST", "/api/v1/worlds", apiErrors(a.require(auth.RoleRender, lim.renders(apiCreateWorldHandler(a, lim, ws, jobs)))))
+	router.Handle("GET", "/api/v1/worlds/:name", apiErrors(a.require(auth.RoleRender, apiGetWorldHandler())))
+	router.Handle("GET", "/api/v1/worlds/:name/render", apiErrors(render(renderHandler(pals))))
+	router.Handle("GET", "/api/v1/worlds/:name/stats", apiErrors(render(statsHandler(cs))))
+	router.Handle("GET", "/api/v1/worlds/:name/heightmap", apiErrors(render(heightmapHandler())))
+	router.Handle("GET", "/api/v1/jobs/:id", apiErrors(a.require(auth.RoleRender, apiGetJobHandler(jobs))))
+	router.Handle("GET", "/api/v1/jobs/:id/events", apiErrors(a.require(auth.RoleRender, apiJobEventsHandler(jobs))))
+	router.Handle("*", "/api/...", apiNotFoundHandler())
+
+	// the older generators create a map for every request, so they are
+	// limited like creating worlds. Their sizes can be set in the query.
+	for _, name := range generators {
+		if !cfg.allows(name) {
+			continue
+		}
+		h := map[string]func(height, width, iterations int) http.HandlerFunc{
+			"asteroids": asteroidsHandler,
+			"fracture":  fractureHandler,
+			"smite":     smiteHandler,
+			"tile":      tileHandler,
+			"tiled":     tiledHandler,
+		}[name]
+		log.Printf("generators: serving /%s\n", name)
+		router.Handle("GET", "/"+name, a.require(auth.RoleGenerate, nextSeedHandler(name)))
+		router.Handle("GET", "/"+name+"/:seed", a.require(auth.RoleGenerate, lim.generates(sizedHandler(cfg, h))))
+	}
 
 	//router.Handle("GET", "/", &templateHandler{filename: "index.gohtml"})
-	//router.HandleFunc("GET", "/fracture", nextSeedHandler("fracture"))
-	//router.HandleFunc("GET", "/fracture/:seed", fractureHandler(height, width, iterations))
-	//router.HandleFunc("GET", "/smite", nextSeedHandler("smite"))
-	//router.HandleFunc("GET", "/smite/:seed", smiteHandler(height, width, iterations))
-	//router.HandleFunc("GET", "/tile", nextSeedHandler("tile"))
-	//router.HandleFunc("GET", "/tile/:seed", tileHandler(height, width, iterations))
-	//router.HandleFunc("GET", "/tiled", nextSeedHandler("tiled"))
-	//router.HandleFunc("GET", "/tiled/:seed", tiledHandler(height, width, iterations))
-	//router.HandleFunc("GET", "/asteroids", nextSeedHandler("asteroids"))
-	//router.HandleFunc("GET", "/asteroids/:seed", asteroidsHandler(height, width, iterations))
-	//router.HandleFunc("GET", "/customize/:seed", customizeHandler("../templates", "customize.gohtml"))
 	//router.HandleFunc("GET", "/carto/:seed", cartoHandler())
 	//router.HandleFunc("GET", "/greyscale/:seed", greyscaleHandler())
 
 	router.NotFound = notFoundHandler()
 
-	log.Fatalln(http.ListenAndServe(":8080", router))
+	srv := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           router,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if cfg.TLSCert != "" {
+		log.Printf("listening on %s (https)\n", cfg.Listen)
+		log.Fatalln(srv.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey))
+	}
+	log.Printf("listening on %s\n", cfg.Listen)
+	log.Fatalln(srv.ListenAndServe())
+}
+
+// sizedHandler lets the query set the height, width and iterations of the
+// older generators, up to the configured limits.
+func sizedHandler(cfg *config, h func(height, width, iterations int) http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		var input struct {
+			height, width, iterations int
+		}
+		if input.height, err = qpvAsInt(r, "height", cfg.Height, 1, cfg.MaxHeight); err != nil {
+		} else if input.width, err = qpvAsInt(r, "width", cfg.Width, 1, cfg.MaxWidth); err != nil {
+		} else if input.iterations, err = qpvAsInt(r, "iterations", cfg.Iterations, 1, cfg.MaxIterations); err != nil {
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+		h(input.height, input.width, input.iterations).ServeHTTP(w, r)
+	}
 }
 
 func asteroidsHandler(height, width, iterations int) http.HandlerFunc {
@@ -90,8 +205,13 @@ func asteroidsHandler(height, width, iterations int) http.HandlerFunc {
 			return
 		}
 
-		m := gen.New(height, width, rand.New(rand.NewSource(int64(seed))))
-		m.RandomFractureCircle(iterations)
+		ctx, cancel := context.WithTimeout(r.Context(), generateTimeout)
+		defer cancel()
+		m := gen.FromSeed(height, width, int64(seed))
+		if err = m.RandomFractureCircleContext(ctx, iterations); err != nil {
+			generatorError(w, r, err)
+			return
+		}
 		m.Normalize()
 
 		png, err := m.AsPNG(m.AsImage())
@@ -120,9 +240,11 @@ func asteroidsHandler(height, width, iterations int) http.HandlerFunc {
 
 func fractureHandler(height, width, iterations int) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		img, err := sliced.Generate(height, width, iterations)
+		ctx, cancel := context.WithTimeout(r.Context(), generateTimeout)
+		defer cancel()
+		img, err := sliced.GenerateContext(ctx, height, width, iterations)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			generatorError(w, r, err)
 			return
 		}
 		bb := &bytes.Buffer{}
@@ -154,9 +276,11 @@ func nextSeedHandler(kind string) http.HandlerFunc {
 
 func smiteHandler(height, width, iterations int) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		img, err := smite.Generate(height, width, iterations)
+		ctx, cancel := context.WithTimeout(r.Context(), generateTimeout)
+		defer cancel()
+		img, err := smite.GenerateContext(ctx, height, width, iterations)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			generatorError(w, r, err)
 			return
 		}
 		bb := &bytes.Buffer{}
@@ -185,9 +309,11 @@ func tileHandler(height, width, iterations int) http.HandlerFunc {
 			return
 		}
 
-		img, err := tiled.Generate(height, width, iterations, rand.New(rand.NewSource(int64(seed))))
+		ctx, cancel := context.WithTimeout(r.Context(), generateTimeout)
+		defer cancel()
+		img, err := tiled.GenerateContext(ctx, height, width, iterations, rand.New(rand.NewSource(int64(seed))))
 		if err != nil {
-			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			generatorError(w, r, err)
 			return
 		}
 		bb := &bytes.Buffer{}
@@ -223,8 +349,13 @@ func tiledHandler(height, width, iterations int) http.HandlerFunc {
 			return
 		}
 
+		ctx, cancel := context.WithTimeout(r.Context(), generateTimeout)
+		defer cancel()
 		m := generator.New(height, width, rand.New(rand.NewSource(int64(seed))))
-		m.RandomFractureCircle(iterations)
+		if err = m.RandomFractureCircleContext(ctx, iterations); err != nil {
+			generatorError(w, r, err)
+			return
+		}
 		m.Normalize()
 		png, err := m.AsPNG()
 		if err != nil {
@@ -242,3 +373,16 @@ func tiledHandler(height, width, iterations int) http.HandlerFunc {
 		log.Printf("tiledHander: %x elapsed %v\n", seed, time.Now().Sub(started))
 	}
 }
+
+// generatorError reports an error from a generator. Nothing is sent if the
+// client has gone away, since there's nobody to read it.
+func generatorError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("%s %s: %v\n", r.Method, r.URL, err)
+	if errors.Is(err, context.Canceled) {
+		return
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, fmt.Sprintf("took longer than %v", generateTimeout), http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+}
@@ -0,0 +1,119 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mdhender/worldgen/pkg/gen"
+	"github.com/mdhender/worldgen/pkg/names"
+	"image"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// corpora are the name generators that users can choose from, by name.
+type corpora map[string]*names.Generator
+
+// loadCorpora reads every corpus (.txt) file in the directory.
+// A missing directory is not an error. There is always a "default" corpus.
+func loadCorpora(root string) (corpora, error) {
+	cs := corpora{"default": names.Default()}
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return cs, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if strings.ToLower(filepath.Ext(entry.Name())) != ".txt" {
+			continue
+		}
+		corpus, err := names.LoadCorpus(filepath.Join(root, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		// small corpora need a shorter chain to make enough new names
+		order := 3
+		if len(corpus) < 100 {
+			order = 2
+		}
+		g, err := names.New(corpus, order, 4, 10)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		cs[strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))] = g
+	}
+	return cs, nil
+}
+
+// qpvAsCorpus returns the name generator from the query parameters.
+func qpvAsCorpus(r *http.Request, cs corpora) (*names.Generator, error) {
+	name, err := qpvAsString(r, "names", "default")
+	if err != nil {
+		return nil, err
+	}
+	if g, ok := cs[name]; ok {
+		return g, nil
+	}
+	return nil, fmt.Errorf("%q: unknown names", name)
+}
+
+// mountain ranges are the highest rangePct percent of the land
+const rangePct = 10
+
+// peak is one of the named mountains of the map.
+type peak struct {
+	Name      string
+	At        image.Point
+	Elevation float64 // meters
+}
+
+// nameWorld names the regions of the map and returns its highest peaks and
+// its mountain ranges, also named. The namer is seeded from the world seed,
+// so the names are the same every time.
+func nameWorld(m *gen.Map, rs *gen.Regions, g *names.Generator, er gen.ElevationRange) ([]peak, []*gen.Region) {
+	// maps saved before the seed was recorded all share the zero seed
+	seed, _ := strconv.ParseUint(m.Metadata().Seed, 16, 64)
+	namer := g.Namer(int64(seed))
+	for _, rg := range rs.List {
+		rg.Name = namer.Name(string(rg.Kind))
+	}
+
+	var peaks []peak
+	elevations := m.Elevations(er)
+	for _, p := range m.Peaks(8, m.Width()/10) {
+		if surface, _ := rs.At(p.X, p.Y); surface == nil || (surface.Kind != gen.Continent && surface.Kind != gen.Island) {
+			continue
+		}
+		peaks = append(peaks, peak{
+			Name:      namer.Name("peak"),
+			At:        p,
+			Elevation: float64(elevations[p.Y*m.Width()+p.X]),
+		})
+	}
+
+	// the ranges are named last so that the regions and peaks keep their names
+	ranges := m.Ranges(rs.SeaLevel, rangePct, minLabeledRegion)
+	for _, rg := range ranges {
+		rg.Name = namer.Name(string(rg.Kind))
+	}
+	return peaks, ranges
+}
@@ -0,0 +1,79 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mdhender/worldgen/pkg/cmap"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// palettes are the cartographic styles that users can choose from, by name.
+type palettes map[string]*cmap.Palette
+
+// loadPalettes reads every palette file in the directory.
+// A missing directory is not an error. There is always a "default" palette.
+func loadPalettes(root string) (palettes, error) {
+	pals := palettes{"default": cmap.DefaultPalette()}
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return pals, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".gpl", ".cpt", ".json":
+		default:
+			continue
+		}
+		p, err := cmap.LoadPalette(filepath.Join(root, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		// the file name is the key, so the names in the files don't collide
+		pals[strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))] = p
+	}
+	return pals, nil
+}
+
+// get returns the palette with the given name. An empty name is the default palette.
+func (p palettes) get(name string) (*cmap.Palette, error) {
+	if name == "" {
+		name = "default"
+	}
+	if pal, ok := p[name]; ok {
+		return pal, nil
+	}
+	return nil, fmt.Errorf("%q: unknown palette", name)
+}
+
+// names returns the names of the palettes, sorted with the default first.
+func (p palettes) names() []string {
+	var names []string
+	for name := range p {
+		if name != "default" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return append([]string{"default"}, names...)
+}
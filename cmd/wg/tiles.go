@@ -0,0 +1,226 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/mdhender/worldgen/pkg/cmap"
+	"github.com/mdhender/worldgen/pkg/gen"
+	"github.com/mdhender/worldgen/pkg/tiles"
+	"github.com/mdhender/worldgen/pkg/way"
+	"image"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// tilesHandler returns a 256x256 tile of a cached map for slippy map viewers.
+// The route is /tiles/:name/:z/:x/:y, where y has a .png suffix.
+//
+// Every tile is cut from a full size image of the map, so the images for the
+// maps being viewed are kept in bases. The tiles themselves are cached like
+// any other render.
+func tilesHandler(bases *tileBases, pals palettes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := way.Param(r.Context(), "name")
+		if !isMapName(name) {
+			http.Error(w, "invalid map name", http.StatusBadRequest)
+			return
+		}
+		pY := way.Param(r.Context(), "y")
+		if !strings.HasSuffix(pY, ".png") {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		var err error
+		var input struct {
+			z, x, y          int
+			layer            string
+			pctWater, pctIce int
+			palette          string // the key, not the name inside the file
+		}
+		if input.z, err = strconv.Atoi(way.Param(r.Context(), "z")); err != nil {
+			err = fmt.Errorf("%q: invalid zoom", "z")
+		} else if input.x, err = strconv.Atoi(way.Param(r.Context(), "x")); err != nil {
+			err = fmt.Errorf("%q: invalid column", "x")
+		} else if input.y, err = strconv.Atoi(strings.TrimSuffix(pY, ".png")); err != nil {
+			err = fmt.Errorf("%q: invalid row", "y")
+		} else if input.layer, err = qpvAsString(r, "layer", "carto"); err != nil {
+		} else if input.pctWater, err = qpvAsInt(r, "pctWater", 55, 0, 100); err != nil {
+		} else if input.pctIce, err = qpvAsInt(r, "pctIce", 8, 0, 100); err != nil {
+		} else if input.palette, err = qpvAsPaletteName(r, pals); err != nil {
+		} else if input.layer != "carto" && input.layer != "shaded" {
+			err = fmt.Errorf("%q: invalid layer", "layer")
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+
+		// the tile position isn't part of the key, so all the tiles share the image
+		key := fmt.Sprintf("%s/%s/%d/%d/%s", name, input.layer, input.pctWater, input.pctIce, input.palette)
+		base, err := bases.get(key, func() (*image.RGBA, error) {
+			return tileBase(name, input.layer, pals[input.palette], input.pctWater, input.pctIce)
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if err = tiles.Validate(input.z, input.x, input.y, tiles.MaxZoom(base.Bounds().Dx())); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusNotFound)
+			return
+		}
+
+		bb := &bytes.Buffer{}
+		if err = png.Encode(bb, tiles.Render(base, input.z, input.x, input.y)); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(bb.Bytes())
+	}
+}
+
+// tileBases holds the full size images that tiles are cut from. The lock
+// only guards the lists, so a slow render doesn't hold up the tiles of
+// other images. Requests for an image that is being rendered wait for it
+// instead of rendering it again.
+type tileBases struct {
+	sync.Mutex
+	images    *lru                   // key to *image.RGBA
+	rendering map[string]*baseRender // by key
+}
+
+// baseRender is an image being rendered.
+type baseRender struct {
+	done chan struct{} // closed when the render is finished
+	img  *image.RGBA
+	err  error
+}
+
+func newTileBases(maxBytes int64) *tileBases {
+	return &tileBases{images: newLRU(maxBytes), rendering: make(map[string]*baseRender)}
+}
+
+// get returns the image for the key, calling render if it isn't cached.
+func (tb *tileBases) get(key string, render func() (*image.RGBA, error)) (*image.RGBA, error) {
+	tb.Lock()
+	if v, ok := tb.images.get(key); ok {
+		tb.Unlock()
+		return v.(*image.RGBA), nil
+	}
+	br, ok := tb.rendering[key]
+	if !ok {
+		br = &baseRender{done: make(chan struct{})}
+		tb.rendering[key] = br
+	}
+	tb.Unlock()
+	if ok {
+		<-br.done
+		return br.img, br.err
+	}
+
+	defer func() {
+		tb.Lock()
+		// the map may have been deleted while it was rendering
+		if tb.rendering[key] == br {
+			delete(tb.rendering, key)
+			if br.err == nil && br.img != nil {
+				tb.images.add(key, br.img, int64(len(br.img.Pix)))
+			}
+		}
+		tb.Unlock()
+		close(br.done)
+	}()
+	br.err = errors.New("render failed") // in case render panics
+	br.img, br.err = render()
+	return br.img, br.err
+}
+
+// forget removes the images of a map that has been deleted.
+func (tb *tileBases) forget(name string) {
+	tb.Lock()
+	defer tb.Unlock()
+	tb.images.removePrefix(name + "/")
+	for key := range tb.rendering {
+		if strings.HasPrefix(key, name+"/") {
+			delete(tb.rendering, key)
+		}
+	}
+}
+
+// tileBase renders the full size image that tiles are cut from.
+func tileBase(name, layer string, palette *cmap.Palette, pctWater, pctIce int) (*image.RGBA, error) {
+	m, err := loadMap(name)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("tiles: rendering %s %s\n", name, layer)
+	cm := palette.ColorMap(m.Histogram(), pctWater, pctIce)
+	if layer == "shaded" {
+		return m.AsShaded(m.AsCarto(cm), gen.DefaultShadeOptions()), nil
+	}
+	return m.AsCarto(cm), nil
+}
+
+// viewHandler returns a page that shows the tiles of a cached map
+// in a viewer that can pan and zoom.
+func viewHandler(root string, pals palettes) http.HandlerFunc {
+	root = filepath.Clean(root)
+	rr := Renderer{}
+	for _, tmpl := range []string{"layout", "view"} {
+		rr.files = append(rr.files, filepath.Join(root, tmpl+".gohtml"))
+	}
+	log.Printf("view: %v\n", rr.files)
+
+	type Data struct {
+		Name     string
+		MaxZoom  int
+		Palettes []string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := way.Param(r.Context(), "name")
+		if !isMapName(name) {
+			http.Error(w, "invalid map name", http.StatusBadRequest)
+			return
+		}
+		md, err := loadMetadata(name)
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+		rr.Render(w, r, Data{Name: name, MaxZoom: tiles.MaxZoom(md.Width), Palettes: pals.names()})
+	}
+}
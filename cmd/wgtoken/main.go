@@ -0,0 +1,59 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package main creates API tokens for the web server.
+//
+// It prints the token, which is shown once and should be given to the user,
+// and the entry to add to the server's users file, which holds only a hash.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/mdhender/worldgen/pkg/auth"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	name := flag.String("name", "", "name of the user")
+	roles := flag.String("roles", string(auth.RoleRender), "comma separated roles: render, generate")
+	flag.Parse()
+
+	token, hash, err := auth.NewToken(*name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	u := auth.User{Name: *name, TokenHash: hash}
+	for _, role := range strings.Split(*roles, ",") {
+		u.Roles = append(u.Roles, auth.Role(strings.TrimSpace(role)))
+	}
+	// NewUsers checks the roles
+	if _, err = auth.NewUsers(nil, &u); err != nil {
+		log.Fatal(err)
+	}
+	data, err := json.Marshal(u)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "token (give this to %s, it can't be recovered):\n", *name)
+	fmt.Println(token)
+	fmt.Fprintf(os.Stderr, "add this to the \"users\" list of the users file:\n")
+	fmt.Println(string(data))
+}
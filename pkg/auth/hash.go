@@ -0,0 +1,109 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package auth implements API tokens, roles and browser sessions.
+//
+// Tokens look like "name.secret". The name picks the user and the secret is
+// checked against a salted PBKDF2-SHA256 hash, so the users file never holds
+// anything that can be used to log in.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultIterations is the PBKDF2 work factor for new hashes.
+const DefaultIterations = 210_000
+
+const hashScheme = "pbkdf2-sha256"
+
+var b64 = base64.RawStdEncoding
+
+// Hash returns a salted hash of the secret in the form
+//
+//	pbkdf2-sha256$<iterations>$<salt>$<key>
+//
+// with the salt and key in unpadded base64.
+func Hash(secret string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2([]byte(secret), salt, DefaultIterations, sha256.Size)
+	return fmt.Sprintf("%s$%d$%s$%s", hashScheme, DefaultIterations, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+// CheckHash returns nil if the secret matches the hash.
+// The keys are compared in constant time.
+func CheckHash(hash, secret string) error {
+	fields := strings.Split(hash, "$")
+	if len(fields) != 4 || fields[0] != hashScheme {
+		return errors.New("unknown hash format")
+	}
+	iterations, err := strconv.Atoi(fields[1])
+	if err != nil || iterations < 1 {
+		return errors.New("invalid hash iterations")
+	}
+	salt, err := b64.DecodeString(fields[2])
+	if err != nil {
+		return errors.New("invalid hash salt")
+	}
+	want, err := b64.DecodeString(fields[3])
+	if err != nil || len(want) == 0 {
+		return errors.New("invalid hash key")
+	}
+	got := pbkdf2([]byte(secret), salt, iterations, len(want))
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// pbkdf2 derives a key from the password as described in RFC 8018, section 5.2.
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	u := make([]byte, prf.Size())
+	t := make([]byte, prf.Size())
+	var counter [4]byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		binary.BigEndian.PutUint32(counter[:], block)
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(counter[:])
+		u = prf.Sum(u[:0])
+		copy(t, u)
+		for n := 1; n < iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
@@ -0,0 +1,72 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestPBKDF2 checks the PBKDF2-HMAC-SHA256 vectors from RFC 7914, section 11.
+func TestPBKDF2(t *testing.T) {
+	for _, tc := range []struct {
+		password, salt string
+		iterations     int
+		want           string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	} {
+		got := hex.EncodeToString(pbkdf2([]byte(tc.password), []byte(tc.salt), tc.iterations, len(tc.want)/2))
+		if got != tc.want {
+			t.Errorf("pbkdf2(%q, %q, %d): want %s, got %s", tc.password, tc.salt, tc.iterations, tc.want, got)
+		}
+	}
+}
+
+func TestCheckHash(t *testing.T) {
+	hash, err := Hash("open sesame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = CheckHash(hash, "open sesame"); err != nil {
+		t.Errorf("right secret: want nil, got %v", err)
+	}
+	for _, secret := range []string{"", "open sesame ", "Open sesame", "open"} {
+		if err = CheckHash(hash, secret); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%q: want %v, got %v", secret, ErrInvalidToken, err)
+		}
+	}
+
+	// a hash that can't be parsed never matches
+	fields := strings.Split(hash, "$")
+	for _, bad := range []string{
+		"",
+		"open sesame",
+		strings.Join(append([]string{"bcrypt"}, fields[1:]...), "$"),
+		strings.Join([]string{fields[0], "0", fields[2], fields[3]}, "$"),
+		strings.Join([]string{fields[0], fields[1], "!", fields[3]}, "$"),
+		strings.Join([]string{fields[0], fields[1], fields[2], ""}, "$"),
+		strings.Join(fields[:3], "$"),
+	} {
+		if err = CheckHash(bad, "open sesame"); err == nil {
+			t.Errorf("%q: want error, got nil", bad)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// Sessions keeps track of the users logged in to the web pages.
+// Only hashes of the session ids are kept, so the ids can't be
+// recovered from memory and lookups don't leak them through timing.
+type Sessions struct {
+	ttl time.Duration
+
+	sync.Mutex
+	sessions map[[sha256.Size]byte]session
+}
+
+type session struct {
+	user    *User
+	expires time.Time
+}
+
+// NewSessions returns an empty set of sessions that last for ttl.
+func NewSessions(ttl time.Duration) *Sessions {
+	return &Sessions{ttl: ttl, sessions: make(map[[sha256.Size]byte]session)}
+}
+
+// TTL returns how long sessions last.
+func (s *Sessions) TTL() time.Duration {
+	return s.ttl
+}
+
+// Create starts a session for the user and returns its id.
+func (s *Sessions) Create(u *User) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := base64.RawURLEncoding.EncodeToString(buf)
+
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for key, sess := range s.sessions {
+		if now.After(sess.expires) {
+			delete(s.sessions, key)
+		}
+	}
+	s.sessions[sha256.Sum256([]byte(id))] = session{user: u, expires: now.Add(s.ttl)}
+	return id, nil
+}
+
+// Get returns the user for the session, or nil if the session
+// doesn't exist or has expired.
+func (s *Sessions) Get(id string) *User {
+	s.Lock()
+	defer s.Unlock()
+	key := sha256.Sum256([]byte(id))
+	sess, ok := s.sessions[key]
+	if !ok {
+		return nil
+	} else if time.Now().After(sess.expires) {
+		delete(s.sessions, key)
+		return nil
+	}
+	return sess.user
+}
+
+// Delete ends the session.
+func (s *Sessions) Delete(id string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.sessions, sha256.Sum256([]byte(id)))
+}
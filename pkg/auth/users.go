@@ -0,0 +1,208 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Role is something a user is allowed to do.
+type Role string
+
+const (
+	RoleRender   Role = "render"   // view cached maps
+	RoleGenerate Role = "generate" // create and delete maps
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// User is someone with an API token.
+type User struct {
+	Name      string `json:"name"`
+	TokenHash string `json:"token_hash"` // from Hash, never the token itself
+	Roles     []Role `json:"roles"`
+}
+
+// Can returns true if the user has the role.
+// A nil user is anonymous and has no roles.
+func (u *User) Can(role Role) bool {
+	if u == nil {
+		return false
+	}
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Users are the users allowed to use the server.
+type Users struct {
+	anonymous []Role
+	byName    map[string]*User
+	dummy     string // checked for unknown users so that they take as long as known ones
+
+	sync.Mutex
+	verified map[[sha256.Size]byte]*User // tokens that have been checked, by their sha256
+}
+
+// NewUsers returns the users. Anonymous requests get the anonymous roles.
+func NewUsers(anonymous []Role, users ...*User) (*Users, error) {
+	dummy, err := Hash("")
+	if err != nil {
+		return nil, err
+	}
+	us := &Users{
+		anonymous: anonymous,
+		byName:    make(map[string]*User),
+		dummy:     dummy,
+		verified:  make(map[[sha256.Size]byte]*User),
+	}
+	for _, role := range anonymous {
+		if err := role.validate(); err != nil {
+			return nil, fmt.Errorf("anonymous: %w", err)
+		}
+	}
+	for _, u := range users {
+		if !validName(u.Name) {
+			return nil, fmt.Errorf("%q: invalid user name", u.Name)
+		} else if us.byName[u.Name] != nil {
+			return nil, fmt.Errorf("%q: duplicate user", u.Name)
+		}
+		for _, role := range u.Roles {
+			if err := role.validate(); err != nil {
+				return nil, fmt.Errorf("%s: %w", u.Name, err)
+			}
+		}
+		us.byName[u.Name] = u
+	}
+	return us, nil
+}
+
+// LoadUsers reads a users file like
+//
+//	{
+//	  "anonymous": ["render"],
+//	  "users": [
+//	    {"name": "alice", "token_hash": "pbkdf2-sha256$...", "roles": ["render", "generate"]}
+//	  ]
+//	}
+func LoadUsers(path string) (*Users, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Anonymous []Role  `json:"anonymous"`
+		Users     []*User `json:"users"`
+	}
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	us, err := NewUsers(file.Anonymous, file.Users...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return us, nil
+}
+
+// Anonymous returns a user with the roles of anonymous requests.
+func (us *Users) Anonymous() *User {
+	return &User{Roles: us.anonymous}
+}
+
+// Len returns the number of users.
+func (us *Users) Len() int {
+	return len(us.byName)
+}
+
+// Authenticate returns the user that the token belongs to.
+// Hashing is slow on purpose, so tokens that have been checked
+// are remembered and not hashed again.
+func (us *Users) Authenticate(token string) (*User, error) {
+	if u := us.Verified(token); u != nil {
+		return u, nil
+	}
+
+	name, secret, _ := strings.Cut(token, ".")
+	u := us.byName[name]
+	if u == nil {
+		_ = CheckHash(us.dummy, secret)
+		return nil, ErrInvalidToken
+	} else if err := CheckHash(u.TokenHash, secret); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	us.Lock()
+	us.verified[sha256.Sum256([]byte(token))] = u
+	us.Unlock()
+	return u, nil
+}
+
+// Verified returns the user if the token has already been authenticated.
+// It returns nil if Authenticate would have to hash the token.
+func (us *Users) Verified(token string) *User {
+	sum := sha256.Sum256([]byte(token))
+	us.Lock()
+	defer us.Unlock()
+	return us.verified[sum]
+}
+
+// NewToken returns a random token for the user and the hash to save in the users file.
+func NewToken(name string) (token, hash string, err error) {
+	if !validName(name) {
+		return "", "", fmt.Errorf("%q: invalid user name", name)
+	}
+	buf := make([]byte, 24)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(buf)
+	if hash, err = Hash(secret); err != nil {
+		return "", "", err
+	}
+	return name + "." + secret, hash, nil
+}
+
+func (r Role) validate() error {
+	if r != RoleRender && r != RoleGenerate {
+		return fmt.Errorf("%q: unknown role", r)
+	}
+	return nil
+}
+
+// validName returns true if the name can be used in a token.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, ch := range name {
+		if !(('a' <= ch && ch <= 'z') || ('0' <= ch && ch <= '9') || ch == '-' || ch == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -23,7 +23,16 @@ type ColorMap [256]color.RGBA
 // FromHistogram converts a histogram into a color map.
 // The histogram should be number of points indexed by "height."
 // (Where height is set by one of the map generators and normalized to 0..255).
+// The colors in each list are spread evenly over the heights for that part of the map.
 func FromHistogram(hs [256]int, pctWater, pctIce int, water, terrain, ice []color.RGBA) ColorMap {
+	return FromRamps(hs, pctWater, pctIce, NewRamp(water...), NewRamp(terrain...), NewRamp(ice...))
+}
+
+// FromRamps converts a histogram into a color map.
+// The lowest pctWater percent of the points are colored from the water ramp,
+// the highest pctIce percent from the ice ramp, and the rest from the terrain ramp.
+// Colors between the stops of a ramp are interpolated.
+func FromRamps(hs [256]int, pctWater, pctIce int, water, terrain, ice Ramp) ColorMap {
 	var cm ColorMap
 
 	// terrain gets whats left
@@ -53,14 +62,17 @@ func FromHistogram(hs [256]int, pctWater, pctIce int, water, terrain, ice []colo
 
 	// update the color map
 	height = 0
-	for i := 0; i < seaLevels; i, height = i+1, height+1 {
-		cm[height] = water[(i*len(water))/seaLevels]
-	}
-	for i := 0; i < terrainLevels; i, height = i+1, height+1 {
-		cm[height] = terrain[(i*len(terrain))/terrainLevels]
-	}
-	for i := 0; i < iceLevels; i, height = i+1, height+1 {
-		cm[height] = ice[(i*len(ice))/iceLevels]
+	for _, part := range []struct {
+		levels int
+		ramp   Ramp
+	}{{seaLevels, water}, {terrainLevels, terrain}, {iceLevels, ice}} {
+		for i := 0; i < part.levels; i, height = i+1, height+1 {
+			t := 0.0
+			if part.levels > 1 {
+				t = float64(i) / float64(part.levels-1)
+			}
+			cm[height] = part.ramp.At(t)
+		}
 	}
 
 	// assign a greyscale to the remaining entries
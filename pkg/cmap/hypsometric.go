@@ -0,0 +1,43 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmap
+
+import "image/color"
+
+// Hypsometric is an elevation ramp with the positions in meters.
+// Below sea level (zero) it shades the ocean floor from deep to shallow,
+// and above it runs through the traditional greens, tans and browns to
+// white on the highest peaks. The two stops at zero make a sharp coastline.
+// Unlike the ramps in FromHistogram, the same color is always the same
+// elevation, so maps colored with it can be compared side by side.
+var Hypsometric = Ramp{
+	{Pos: -11000, Color: color.RGBA{R: 8, G: 16, B: 48, A: 255}},
+	{Pos: -6000, Color: color.RGBA{R: 16, G: 40, B: 100, A: 255}},
+	{Pos: -4000, Color: color.RGBA{R: 28, G: 72, B: 148, A: 255}},
+	{Pos: -2000, Color: color.RGBA{R: 48, G: 110, B: 190, A: 255}},
+	{Pos: -200, Color: color.RGBA{R: 100, G: 160, B: 220, A: 255}},
+	{Pos: 0, Color: color.RGBA{R: 150, G: 200, B: 240, A: 255}},
+	{Pos: 0, Color: color.RGBA{R: 60, G: 130, B: 70, A: 255}},
+	{Pos: 200, Color: color.RGBA{R: 110, G: 160, B: 90, A: 255}},
+	{Pos: 500, Color: color.RGBA{R: 170, G: 190, B: 110, A: 255}},
+	{Pos: 1000, Color: color.RGBA{R: 220, G: 210, B: 140, A: 255}},
+	{Pos: 2000, Color: color.RGBA{R: 200, G: 160, B: 100, A: 255}},
+	{Pos: 3000, Color: color.RGBA{R: 160, G: 110, B: 70, A: 255}},
+	{Pos: 4500, Color: color.RGBA{R: 130, G: 100, B: 90, A: 255}},
+	{Pos: 6000, Color: color.RGBA{R: 220, G: 220, B: 220, A: 255}},
+	{Pos: 9000, Color: color.RGBA{R: 255, G: 255, B: 255, A: 255}},
+}
@@ -0,0 +1,332 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Palette is a cartographic style, with a color ramp for each part of the map.
+// Elevations is used instead of the other ramps when coloring by elevation in meters.
+type Palette struct {
+	Name       string
+	Water      Ramp
+	Terrain    Ramp
+	Ice        Ramp
+	Elevations Ramp
+}
+
+// DefaultPalette returns the built-in Water, Terrain, Ice and Hypsometric colors.
+func DefaultPalette() *Palette {
+	return &Palette{Name: "default", Water: NewRamp(Water...), Terrain: NewRamp(Terrain...), Ice: NewRamp(Ice...), Elevations: Hypsometric}
+}
+
+// ColorMap returns the color map for a histogram using the palette.
+func (p *Palette) ColorMap(hs [256]int, pctWater, pctIce int) ColorMap {
+	return FromRamps(hs, pctWater, pctIce, p.Water, p.Terrain, p.Ice)
+}
+
+// fill uses the default ramps for any that are missing.
+func (p *Palette) fill() *Palette {
+	dflt := DefaultPalette()
+	if len(p.Water) == 0 {
+		p.Water = dflt.Water
+	}
+	if len(p.Terrain) == 0 {
+		p.Terrain = dflt.Terrain
+	}
+	if len(p.Ice) == 0 {
+		p.Ice = dflt.Ice
+	}
+	if len(p.Elevations) == 0 {
+		p.Elevations = dflt.Elevations
+	}
+	return p
+}
+
+// LoadPalette reads a palette file. The format is chosen by the extension:
+// ".gpl" for GIMP palettes, ".cpt" for GMT color tables and ".json" for our gradients.
+// The name of the palette defaults to the name of the file.
+func LoadPalette(path string) (*Palette, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	var p *Palette
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".gpl":
+		p, err = ReadGPL(fp)
+	case ".cpt":
+		p, err = ReadCPT(fp)
+	case ".json":
+		p, err = ReadJSON(fp)
+	default:
+		return nil, fmt.Errorf("cmap: %s: unknown palette format %q", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cmap: %s: %w", path, err)
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return p, nil
+}
+
+// ReadGPL reads a GIMP palette. The colors are spaced evenly along the ramps.
+// Colors whose names start with "water" or "ice" go in those ramps and
+// all the others go in the terrain ramp. Missing ramps use the defaults.
+func ReadGPL(r io.Reader) (*Palette, error) {
+	sc := bufio.NewScanner(r)
+	if !sc.Scan() || strings.TrimSpace(sc.Text()) != "GIMP Palette" {
+		return nil, fmt.Errorf("gpl: missing header")
+	}
+	p := &Palette{}
+	var water, terrain, ice []color.RGBA
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, "Columns:") {
+			continue
+		} else if name, ok := strings.CutPrefix(text, "Name:"); ok {
+			p.Name = strings.TrimSpace(name)
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("gpl: line %d: want r g b", line+1)
+		}
+		c, err := parseRGB(fields[:3])
+		if err != nil {
+			return nil, fmt.Errorf("gpl: line %d: %w", line+1, err)
+		}
+		name := strings.ToLower(strings.Join(fields[3:], " "))
+		switch {
+		case strings.HasPrefix(name, "water"):
+			water = append(water, c)
+		case strings.HasPrefix(name, "ice"):
+			ice = append(ice, c)
+		default:
+			terrain = append(terrain, c)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	} else if len(water)+len(terrain)+len(ice) == 0 {
+		return nil, fmt.Errorf("gpl: no colors")
+	}
+	p.Water, p.Terrain, p.Ice = NewRamp(water...), NewRamp(terrain...), NewRamp(ice...)
+	return p.fill(), nil
+}
+
+// ReadCPT reads a GMT color palette table with RGB colors.
+// Following the GMT convention, zero is sea level, so the part of the table
+// below zero becomes the water ramp and the rest becomes the terrain ramp.
+// The table is also used as is, in meters, for the elevation ramp.
+// The background, foreground and NaN colors are ignored.
+func ReadCPT(r io.Reader) (*Palette, error) {
+	sc := bufio.NewScanner(r)
+	var stops Ramp
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			if strings.Contains(text, "COLOR_MODEL") && !strings.Contains(strings.ToUpper(text), "RGB") {
+				return nil, fmt.Errorf("cpt: line %d: only the RGB color model is supported", line)
+			}
+			continue
+		}
+		fields := strings.Fields(text)
+		if fields[0] == "B" || fields[0] == "F" || fields[0] == "N" {
+			continue
+		}
+		// each line is "z0 color z1 color [label]" where a color is "r g b", "r/g/b" or "#rrggbb"
+		var zs []float64
+		var colors []color.RGBA
+		for len(fields) != 0 && len(zs) < 2 {
+			z, err := strconv.ParseFloat(fields[0], 64)
+			if err != nil {
+				return nil, fmt.Errorf("cpt: line %d: %w", line, err)
+			}
+			fields = fields[1:]
+			var c color.RGBA
+			if len(fields) != 0 && (strings.HasPrefix(fields[0], "#") || strings.Contains(fields[0], "/")) {
+				if c, err = parseColor(fields[0]); err != nil {
+					return nil, fmt.Errorf("cpt: line %d: %w", line, err)
+				}
+				fields = fields[1:]
+			} else if len(fields) >= 3 {
+				if c, err = parseRGB(fields[:3]); err != nil {
+					return nil, fmt.Errorf("cpt: line %d: %w", line, err)
+				}
+				fields = fields[3:]
+			} else {
+				return nil, fmt.Errorf("cpt: line %d: missing color", line)
+			}
+			zs, colors = append(zs, z), append(colors, c)
+		}
+		if len(zs) != 2 {
+			return nil, fmt.Errorf("cpt: line %d: want z0 color z1 color", line)
+		}
+		stops = append(stops, Stop{Pos: zs[0], Color: colors[0]}, Stop{Pos: zs[1], Color: colors[1]})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	} else if len(stops) == 0 {
+		return nil, fmt.Errorf("cpt: no colors")
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range stops {
+		lo, hi = math.Min(lo, s.Pos), math.Max(hi, s.Pos)
+	}
+	p := &Palette{Elevations: append(Ramp{}, stops...).sorted()}
+	if lo < 0 {
+		// the stops are in pairs, one pair for each line of the table
+		for n := 0; n < len(stops); n += 2 {
+			if stops[n].Pos < 0 {
+				p.Water = append(p.Water, stops[n], stops[n+1])
+			}
+			if stops[n+1].Pos > 0 {
+				p.Terrain = append(p.Terrain, stops[n], stops[n+1])
+			}
+		}
+		p.Water, p.Terrain = p.Water.normalize(lo, 0), p.Terrain.normalize(0, hi)
+	} else {
+		p.Terrain = stops.normalize(lo, hi)
+	}
+	return p.fill(), nil
+}
+
+// jsonPalette is our gradient format. For example,
+//
+//	{
+//	  "name": "arctic",
+//	  "water": [{"pos": 0, "color": "#001030"}, {"pos": 1, "color": "#4080c0"}],
+//	  "terrain": ["#2c5a2c", "#a0a070", "#ffffff"]
+//	}
+//
+// Each ramp is a list of stops or of colors. Colors without a position
+// are spaced evenly. The "elevations" ramp is a list of stops with the
+// positions in meters. Missing ramps use the defaults.
+type jsonPalette struct {
+	Name       string            `json:"name"`
+	Water      []json.RawMessage `json:"water"`
+	Terrain    []json.RawMessage `json:"terrain"`
+	Ice        []json.RawMessage `json:"ice"`
+	Elevations []jsonStop        `json:"elevations"`
+}
+
+type jsonStop struct {
+	Pos   *float64 `json:"pos"`
+	Color string   `json:"color"`
+}
+
+// ReadJSON reads a palette in our JSON gradient format.
+func ReadJSON(r io.Reader) (*Palette, error) {
+	var jp jsonPalette
+	if err := json.NewDecoder(r).Decode(&jp); err != nil {
+		return nil, err
+	}
+	p := &Palette{Name: jp.Name}
+	for _, part := range []struct {
+		name string
+		raw  []json.RawMessage
+		ramp *Ramp
+	}{{"water", jp.Water, &p.Water}, {"terrain", jp.Terrain, &p.Terrain}, {"ice", jp.Ice, &p.Ice}} {
+		var ramp Ramp
+		for n, raw := range part.raw {
+			var js jsonStop
+			if err := json.Unmarshal(raw, &js.Color); err != nil {
+				if err = json.Unmarshal(raw, &js); err != nil {
+					return nil, fmt.Errorf("%s: stop %d: %w", part.name, n, err)
+				}
+			}
+			c, err := parseColor(js.Color)
+			if err != nil {
+				return nil, fmt.Errorf("%s: stop %d: %w", part.name, n, err)
+			}
+			stop := Stop{Color: c, Pos: math.NaN()}
+			if js.Pos != nil {
+				stop.Pos = *js.Pos
+			}
+			ramp = append(ramp, stop)
+		}
+		// space out the stops without positions
+		for n := range ramp {
+			if math.IsNaN(ramp[n].Pos) {
+				ramp[n].Pos = 0
+				if len(ramp) > 1 {
+					ramp[n].Pos = float64(n) / float64(len(ramp)-1)
+				}
+			} else if ramp[n].Pos < 0 || ramp[n].Pos > 1 {
+				return nil, fmt.Errorf("%s: stop %d: position must be 0..1", part.name, n)
+			}
+		}
+		*part.ramp = ramp.normalize(0, 1)
+	}
+	for n, js := range jp.Elevations {
+		c, err := parseColor(js.Color)
+		if err != nil {
+			return nil, fmt.Errorf("elevations: stop %d: %w", n, err)
+		} else if js.Pos == nil {
+			return nil, fmt.Errorf("elevations: stop %d: missing position", n)
+		}
+		p.Elevations = append(p.Elevations, Stop{Pos: *js.Pos, Color: c})
+	}
+	p.Elevations = p.Elevations.sorted()
+	return p.fill(), nil
+}
+
+// parseRGB parses three decimal color components.
+func parseRGB(fields []string) (color.RGBA, error) {
+	var rgb [3]uint8
+	for n, f := range fields {
+		v, err := strconv.Atoi(f)
+		if err != nil {
+			return color.RGBA{}, err
+		} else if v < 0 || v > 255 {
+			return color.RGBA{}, fmt.Errorf("color component %d out of range", v)
+		}
+		rgb[n] = uint8(v)
+	}
+	return color.RGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: 255}, nil
+}
+
+// parseColor parses "#rrggbb" or "r/g/b".
+func parseColor(s string) (color.RGBA, error) {
+	if hex, ok := strings.CutPrefix(s, "#"); ok {
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || len(hex) != 6 {
+			return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+		}
+		return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+	}
+	fields := strings.Split(s, "/")
+	if len(fields) != 3 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	return parseRGB(fields)
+}
@@ -0,0 +1,88 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmap
+
+import (
+	"image/color"
+	"math"
+	"sort"
+)
+
+// Stop is a color at a position along a Ramp.
+// The position is 0..1 for the ramps in a color map and meters for elevation ramps.
+type Stop struct {
+	Pos   float64
+	Color color.RGBA
+}
+
+// Ramp is a color gradient made of stops sorted by position.
+// Two stops at the same position make a hard edge.
+type Ramp []Stop
+
+// NewRamp returns a ramp with the colors spaced evenly from 0 to 1.
+func NewRamp(colors ...color.RGBA) Ramp {
+	r := make(Ramp, len(colors))
+	for n, c := range colors {
+		r[n].Color = c
+		if len(colors) > 1 {
+			r[n].Pos = float64(n) / float64(len(colors)-1)
+		}
+	}
+	return r
+}
+
+// sorted sorts the stops by position, keeping stops at the same position in order.
+func (r Ramp) sorted() Ramp {
+	sort.SliceStable(r, func(i, j int) bool {
+		return r[i].Pos < r[j].Pos
+	})
+	return r
+}
+
+// normalize sorts the stops and rescales their positions from lo..hi to 0..1.
+func (r Ramp) normalize(lo, hi float64) Ramp {
+	r = r.sorted()
+	for n := range r {
+		if hi > lo {
+			r[n].Pos = (r[n].Pos - lo) / (hi - lo)
+		} else {
+			r[n].Pos = 0
+		}
+	}
+	return r
+}
+
+// At returns the color at position t, interpolating between the stops on either side.
+// Positions before the first stop or after the last get the color of that stop.
+func (r Ramp) At(t float64) color.RGBA {
+	if len(r) == 0 {
+		return color.RGBA{A: 255}
+	} else if t <= r[0].Pos {
+		return r[0].Color
+	}
+	for n := 1; n < len(r); n++ {
+		if t < r[n].Pos {
+			a, b := r[n-1], r[n]
+			f := (t - a.Pos) / (b.Pos - a.Pos)
+			lerp := func(x, y uint8) uint8 {
+				return uint8(math.Round(float64(x) + f*(float64(y)-float64(x))))
+			}
+			return color.RGBA{R: lerp(a.Color.R, b.Color.R), G: lerp(a.Color.G, b.Color.G), B: lerp(a.Color.B, b.Color.B), A: lerp(a.Color.A, b.Color.A)}
+		}
+	}
+	return r[len(r)-1].Color
+}
@@ -0,0 +1,218 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package contour traces contour lines through a grid using marching squares.
+//
+// The grid wraps east to west like our maps, so lines that cross the
+// edge of the map are stitched into a single line.
+package contour
+
+// Point is a position on the grid. The value at row y, column x is at (x, y).
+type Point struct {
+	X, Y float64
+}
+
+// Line is a contour line at a single level.
+// The X values are in the range 0..width, so consecutive points may jump
+// from one side of the map to the other where the line crosses the edge.
+type Line struct {
+	Level  float64
+	Closed bool
+	Points []Point
+}
+
+// edge identifies the edge between two neighboring values.
+// Horizontal edges run from (x, y) to (x+1, y), vertical ones from (x, y) to (x, y+1).
+type edge struct {
+	x, y     int
+	vertical bool
+}
+
+type segment struct {
+	a, b edge
+}
+
+// the edges that a line crosses for each case.
+// the case is tl<<3 | tr<<2 | br<<1 | bl, where a corner is 1 if it is at or above the level.
+// the saddles (5 and 10) are resolved by looking at the center of the cell.
+const (
+	top = iota
+	right
+	bottom
+	left
+)
+
+var cases = [16][][2]int{
+	0:  nil,
+	1:  {{left, bottom}},
+	2:  {{bottom, right}},
+	3:  {{left, right}},
+	4:  {{top, right}},
+	5:  {{left, top}, {bottom, right}}, // assumes the center is high
+	6:  {{top, bottom}},
+	7:  {{left, top}},
+	8:  {{left, top}},
+	9:  {{top, bottom}},
+	10: {{left, bottom}, {top, right}}, // assumes the center is high
+	11: {{top, right}},
+	12: {{left, right}},
+	13: {{bottom, right}},
+	14: {{left, bottom}},
+	15: nil,
+}
+
+// Trace returns the contour lines at the given level.
+// The values are in row order.
+func Trace(values []float32, width, height int, level float64) []Line {
+	if width < 2 || height < 2 || len(values) != width*height {
+		return nil
+	}
+	at := func(x, y int) float64 {
+		return float64(values[y*width+x%width])
+	}
+
+	// find the segments in each cell. there are width cells in each row because of the wrap.
+	var segments []segment
+	for y := 0; y+1 < height; y++ {
+		for x := 0; x < width; x++ {
+			tl, tr, br, bl := at(x, y), at(x+1, y), at(x+1, y+1), at(x, y+1)
+			c := 0
+			if tl >= level {
+				c |= 8
+			}
+			if tr >= level {
+				c |= 4
+			}
+			if br >= level {
+				c |= 2
+			}
+			if bl >= level {
+				c |= 1
+			}
+			pairs := cases[c]
+			if (c == 5 || c == 10) && (tl+tr+br+bl)/4 < level {
+				// the center is below the level, so the high corners are not connected
+				pairs = cases[15-c]
+			}
+			edges := [4]edge{
+				top:    {x: x, y: y},
+				right:  {x: (x + 1) % width, y: y, vertical: true},
+				bottom: {x: x, y: y + 1},
+				left:   {x: x, y: y, vertical: true},
+			}
+			for _, pair := range pairs {
+				segments = append(segments, segment{a: edges[pair[0]], b: edges[pair[1]]})
+			}
+		}
+	}
+
+	// an edge is shared by at most two segments, one from each cell next to it
+	byEdge := make(map[edge][]int, 2*len(segments))
+	for n, s := range segments {
+		byEdge[s.a] = append(byEdge[s.a], n)
+		byEdge[s.b] = append(byEdge[s.b], n)
+	}
+	used := make([]bool, len(segments))
+	// follow returns the edges crossed by the line after leaving segment n through edge e.
+	follow := func(n int, e edge) (edges []edge) {
+		for found := true; found; {
+			found = false
+			for _, m := range byEdge[e] {
+				if m != n && !used[m] {
+					used[m], n, found = true, m, true
+					if segments[m].a == e {
+						e = segments[m].b
+					} else {
+						e = segments[m].a
+					}
+					edges = append(edges, e)
+					break
+				}
+			}
+		}
+		return edges
+	}
+
+	point := func(e edge) Point {
+		x1, y1 := e.x, e.y
+		if e.vertical {
+			y1++
+		} else {
+			x1++
+		}
+		v0, v1 := at(e.x, e.y), at(x1, y1)
+		t := 0.5
+		if v0 != v1 {
+			t = (level - v0) / (v1 - v0)
+		}
+		if e.vertical {
+			return Point{X: float64(e.x), Y: float64(e.y) + t}
+		}
+		return Point{X: float64(e.x) + t, Y: float64(e.y)}
+	}
+
+	var lines []Line
+	for start := range segments {
+		if used[start] {
+			continue
+		}
+		used[start] = true
+		forward := append([]edge{segments[start].a, segments[start].b}, follow(start, segments[start].b)...)
+		closed := len(forward) > 2 && forward[0] == forward[len(forward)-1]
+		var backward []edge
+		if !closed {
+			backward = follow(start, segments[start].a)
+		}
+
+		line := Line{Level: level, Closed: closed}
+		for i := len(backward) - 1; i >= 0; i-- {
+			line.Points = append(line.Points, point(backward[i]))
+		}
+		for _, e := range forward {
+			line.Points = append(line.Points, point(e))
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Split breaks the line where it crosses the east-west edge of the map,
+// which is useful when drawing it. Each part is extended past the edge
+// of the map so that the parts meet when the map is drawn.
+func (l Line) Split(width int) [][]Point {
+	w := float64(width)
+	var parts [][]Point
+	var part []Point
+	for n, p := range l.Points {
+		if n != 0 {
+			prev := l.Points[n-1]
+			if dx := p.X - prev.X; dx > w/2 {
+				// crossed the west edge
+				parts = append(parts, append(part, Point{X: p.X - w, Y: p.Y}))
+				part = []Point{{X: prev.X + w, Y: prev.Y}}
+			} else if dx < -w/2 {
+				// crossed the east edge
+				parts = append(parts, append(part, Point{X: p.X + w, Y: p.Y}))
+				part = []Point{{X: prev.X - w, Y: prev.Y}}
+			}
+		}
+		part = append(part, p)
+	}
+	if len(part) != 0 {
+		parts = append(parts, part)
+	}
+	return parts
+}
@@ -0,0 +1,72 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package contour
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+)
+
+// Layer is a group of lines drawn with the same style.
+type Layer struct {
+	Name        string // used as the id of the group
+	Stroke      string // any SVG color
+	StrokeWidth float64
+	Lines       []Line
+}
+
+// WriteSVG draws the layers, in order, on a canvas the size of the map.
+// The values in the grid are drawn at the centers of the pixels so that
+// the lines line up with images of the map.
+func WriteSVG(w io.Writer, width, height int, background string, layers []Layer) error {
+	bw := bufio.NewWriter(w)
+	_, _ = fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", width, height, width, height)
+	if background != "" {
+		_, _ = fmt.Fprintf(bw, `<rect width="100%%" height="100%%" fill="%s"/>`+"\n", html.EscapeString(background))
+	}
+	// clip the parts that were extended past the edge of the map
+	_, _ = fmt.Fprintf(bw, `<clipPath id="map"><rect width="%d" height="%d"/></clipPath>`+"\n", width, height)
+	for _, layer := range layers {
+		_, _ = fmt.Fprintf(bw, `<g id="%s" clip-path="url(#map)" fill="none" stroke="%s" stroke-width="%g" stroke-linejoin="round" stroke-linecap="round" transform="translate(0.5 0.5)">`+"\n",
+			html.EscapeString(layer.Name), html.EscapeString(layer.Stroke), layer.StrokeWidth)
+		for _, line := range layer.Lines {
+			parts := line.Split(width)
+			for _, part := range parts {
+				if len(part) < 2 {
+					continue
+				}
+				_, _ = fmt.Fprintf(bw, `<path data-level="%g" d="`, line.Level)
+				for n, p := range part {
+					cmd := 'L'
+					if n == 0 {
+						cmd = 'M'
+					}
+					_, _ = fmt.Fprintf(bw, "%c%.2f %.2f", cmd, p.X, p.Y)
+				}
+				if line.Closed && len(parts) == 1 {
+					_, _ = fmt.Fprint(bw, "Z")
+				}
+				_, _ = fmt.Fprint(bw, "\"/>\n")
+			}
+		}
+		_, _ = fmt.Fprint(bw, "</g>\n")
+	}
+	_, _ = fmt.Fprint(bw, "</svg>\n")
+	return bw.Flush()
+}
@@ -0,0 +1,379 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package decor draws map decorations (graticules, legends, scale bars,
+// title blocks, compasses and place names) on top of rendered maps.
+//
+// The maps are equirectangular, covering 360 degrees of longitude from
+// left to right and 180 degrees of latitude from top to bottom.
+package decor
+
+import (
+	"fmt"
+	"github.com/mdhender/worldgen/pkg/text"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+var (
+	ink   = color.NRGBA{R: 32, G: 32, B: 32, A: 255}
+	paper = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	panel = color.NRGBA{R: 255, G: 255, B: 255, A: 208}
+)
+
+// Options controls the decorations added by Decorate.
+type Options struct {
+	Graticule int      // degrees between the lines of the graticule, 0 for none
+	Legend    *Legend  // nil for no legend
+	Radius    float64  // radius of the planet in km for the scale bar, 0 for none
+	Title     string   // title block, empty for none
+	Subtitle  []string // lines under the title
+	Compass   bool
+	Labels    []Label // place names, placed so that they don't overlap
+	Scale     int     // size of the text and decorations, in pixels per font pixel
+}
+
+// Decorate draws the decorations on the map. The graticule is drawn under
+// everything else and the labels are kept clear of the other decorations.
+// The compass goes in the top-left corner, the legend in the top-right,
+// the title block in the bottom-left and the scale bar in the bottom-right.
+func Decorate(img draw.Image, opts Options) {
+	scale := opts.Scale
+	if scale < 1 {
+		scale = 1
+	}
+	b := img.Bounds()
+	margin := 6 * scale
+
+	if opts.Graticule > 0 {
+		Graticule(img, opts.Graticule, scale)
+	}
+
+	// find the panels first so that the labels can stay out of their way
+	var compass, legend, title, scaleBar image.Rectangle
+	// below the labels of the graticule
+	compassAt := image.Pt(b.Min.X+margin+8*scale, b.Min.Y+margin+text.Height(scale)+20*scale)
+	if opts.Compass {
+		compass = CompassBounds(compassAt, scale)
+	}
+	if opts.Legend != nil {
+		size := opts.Legend.Size(scale)
+		legend = image.Rectangle{Min: image.Pt(b.Max.X-margin-size.X, b.Min.Y+margin)}
+		legend.Max = legend.Min.Add(size)
+	}
+	if opts.Title != "" {
+		size := TitleBlockSize(opts.Title, opts.Subtitle, scale)
+		title = image.Rectangle{Min: image.Pt(b.Min.X+margin, b.Max.Y-margin-size.Y)}
+		title.Max = title.Min.Add(size)
+	}
+	if opts.Radius > 0 {
+		size := ScaleBarSize(b.Dx(), opts.Radius, scale)
+		scaleBar = image.Rectangle{Min: b.Max.Sub(image.Pt(margin, margin)).Sub(size), Max: b.Max.Sub(image.Pt(margin, margin))}
+	}
+
+	if len(opts.Labels) != 0 {
+		reserved := []image.Rectangle{compass, legend, title, scaleBar}
+		if opts.Graticule > 0 {
+			// the labels of the graticule run along the top and left edges
+			strip := text.Height(scale) + 4*scale
+			reserved = append(reserved, image.Rect(b.Min.X, b.Min.Y, b.Max.X, b.Min.Y+strip))
+			reserved = append(reserved, image.Rect(b.Min.X, b.Min.Y, b.Min.X+text.Width("90°N", scale)+4*scale, b.Max.Y))
+		}
+		PlaceLabels(img, opts.Labels, reserved)
+	}
+	if opts.Compass {
+		Compass(img, compassAt, scale)
+	}
+	if opts.Legend != nil {
+		opts.Legend.Draw(img, legend.Min, scale)
+	}
+	if opts.Title != "" {
+		TitleBlock(img, title.Min, opts.Title, opts.Subtitle, scale)
+	}
+	if opts.Radius > 0 {
+		ScaleBar(img, scaleBar.Max, opts.Radius, scale)
+	}
+}
+
+// fill draws a rectangle, blending the color over the image.
+func fill(img draw.Image, r image.Rectangle, c color.Color) {
+	draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Over)
+}
+
+// Graticule draws lines of latitude and longitude every step degrees and
+// labels them along the left and top edges. The equator and the prime
+// meridian are drawn twice as wide as the other lines.
+func Graticule(img draw.Image, step int, scale int) {
+	b := img.Bounds()
+	width, height := float64(b.Dx()), float64(b.Dy())
+	line := color.NRGBA{R: 255, G: 255, B: 255, A: 96}
+
+	for lat := -90 + step; lat < 90; lat += step {
+		y := b.Min.Y + int(math.Round((90-float64(lat))*height/180))
+		w := 1
+		if lat == 0 {
+			w = 2
+		}
+		fill(img, image.Rect(b.Min.X, y-w/2, b.Max.X, y-w/2+w), line)
+		label := fmt.Sprintf("%d°N", lat)
+		if lat < 0 {
+			label = fmt.Sprintf("%d°S", -lat)
+		} else if lat == 0 {
+			label = "0°"
+		}
+		text.DrawHalo(img, image.Pt(b.Min.X+2*scale, y+2*scale), label, scale, ink, paper)
+	}
+	for lon := -180 + step; lon < 180; lon += step {
+		x := b.Min.X + int(math.Round((float64(lon)+180)*width/360))
+		w := 1
+		if lon == 0 {
+			w = 2
+		}
+		fill(img, image.Rect(x-w/2, b.Min.Y, x-w/2+w, b.Max.Y), line)
+		label := fmt.Sprintf("%d°E", lon)
+		if lon < 0 {
+			label = fmt.Sprintf("%d°W", -lon)
+		} else if lon == 0 {
+			label = "0°"
+		}
+		text.DrawHalo(img, image.Pt(x+2*scale, b.Min.Y+2*scale), label, scale, ink, paper)
+	}
+}
+
+// Tick is a labeled position along a legend, from 0 (bottom) to 1 (top).
+type Tick struct {
+	Pos   float64
+	Label string
+}
+
+// Legend is a color bar with labels.
+type Legend struct {
+	Title  string
+	Colors []color.RGBA // from the bottom of the bar to the top
+	Ticks  []Tick
+}
+
+const (
+	legendBarWidth  = 12
+	legendBarHeight = 120
+)
+
+// Size returns the size of the legend in pixels.
+func (l *Legend) Size(scale int) image.Point {
+	pad := 4 * scale
+	w := text.Width(l.Title, scale)
+	labels := 0
+	for _, t := range l.Ticks {
+		if tw := text.Width(t.Label, scale); tw > labels {
+			labels = tw
+		}
+	}
+	if bw := (legendBarWidth+4)*scale + labels; bw > w {
+		w = bw
+	}
+	h := legendBarHeight * scale
+	if l.Title != "" {
+		h += text.Height(scale)
+	}
+	// leave room for the labels at the ends of the bar
+	return image.Pt(w+2*pad, h+2*pad+text.Height(scale))
+}
+
+// Draw draws the legend with its top-left corner at pt.
+func (l *Legend) Draw(img draw.Image, pt image.Point, scale int) {
+	size := l.Size(scale)
+	pad := 4 * scale
+	fill(img, image.Rectangle{Min: pt, Max: pt.Add(size)}, panel)
+
+	y := pt.Y + pad
+	if l.Title != "" {
+		text.Draw(img, image.Pt(pt.X+pad, y), l.Title, scale, ink)
+		y += text.Height(scale)
+	}
+	// half a line of space so the label at the top of the bar fits
+	y += text.Height(scale) / 2
+	bar := image.Rect(pt.X+pad, y, pt.X+pad+legendBarWidth*scale, y+legendBarHeight*scale)
+	if len(l.Colors) != 0 {
+		for row := bar.Min.Y; row < bar.Max.Y; row++ {
+			t := float64(bar.Max.Y-1-row) / float64(bar.Dy()-1)
+			c := l.Colors[int(math.Round(t*float64(len(l.Colors)-1)))]
+			fill(img, image.Rect(bar.Min.X, row, bar.Max.X, row+1), c)
+		}
+	}
+	outline(img, bar, ink)
+
+	for _, t := range l.Ticks {
+		ty := bar.Max.Y - 1 - int(math.Round(t.Pos*float64(bar.Dy()-1)))
+		fill(img, image.Rect(bar.Max.X, ty, bar.Max.X+2*scale, ty+1), ink)
+		text.Draw(img, image.Pt(bar.Max.X+4*scale, ty-7*scale/2), t.Label, scale, ink)
+	}
+}
+
+// outline draws a one pixel line around the inside of the rectangle.
+func outline(img draw.Image, r image.Rectangle, c color.Color) {
+	fill(img, image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+1), c)
+	fill(img, image.Rect(r.Min.X, r.Max.Y-1, r.Max.X, r.Max.Y), c)
+	fill(img, image.Rect(r.Min.X, r.Min.Y+1, r.Min.X+1, r.Max.Y-1), c)
+	fill(img, image.Rect(r.Max.X-1, r.Min.Y+1, r.Max.X, r.Max.Y-1), c)
+}
+
+// ScaleBarSize returns the size of the scale bar in pixels.
+// It is empty if the map is too narrow for a useful bar.
+func ScaleBarSize(width int, radius float64, scale int) image.Point {
+	_, _, size := scaleBar(width, radius, scale)
+	return size
+}
+
+// scaleBar returns the length of the bar in km and pixels and the size of its box.
+func scaleBar(width int, radius float64, scale int) (km float64, length int, size image.Point) {
+	kmPerPixel := 2 * math.Pi * radius / float64(width)
+
+	// the longest round length that fits in a fifth of the map
+	maxKm := kmPerPixel * float64(width) / 5
+	km = math.Pow(10, math.Floor(math.Log10(maxKm)))
+	for _, f := range []float64{5, 2} {
+		if km*f <= maxKm {
+			km *= f
+			break
+		}
+	}
+	length = int(math.Round(km / kmPerPixel))
+	if length < 4 {
+		return km, length, image.Point{}
+	}
+
+	pad := 4 * scale
+	w := length + text.Width(fmt.Sprintf("%g km", km), scale)/2 + text.Width("0", scale)/2
+	if nw := text.Width(scaleBarNote, scale); nw > w {
+		w = nw
+	}
+	h := 4*scale + 2*text.Height(scale)
+	return km, length, image.Pt(w+2*pad, h+2*pad)
+}
+
+const scaleBarNote = "at the equator"
+
+// ScaleBar draws a scale bar with its bottom-right corner at pt.
+// The map covers the planet, so the scale is only true along the equator.
+// Nothing is drawn if the map is too narrow for a useful bar.
+func ScaleBar(img draw.Image, pt image.Point, radius float64, scale int) {
+	km, length, size := scaleBar(img.Bounds().Dx(), radius, scale)
+	if size == (image.Point{}) {
+		return
+	}
+
+	pad := 4 * scale
+	label := fmt.Sprintf("%g km", km)
+	box := image.Rectangle{Min: pt.Sub(size), Max: pt}
+	fill(img, box, panel)
+
+	x0 := box.Min.X + pad + text.Width("0", scale)/2
+	y0 := box.Min.Y + pad + text.Height(scale)
+	for i := 0; i < 4; i++ {
+		c := color.Color(ink)
+		if i%2 == 1 {
+			c = paper
+		}
+		fill(img, image.Rect(x0+i*length/4, y0, x0+(i+1)*length/4, y0+4*scale), c)
+	}
+	outline(img, image.Rect(x0, y0, x0+length, y0+4*scale), ink)
+	text.Draw(img, image.Pt(x0-text.Width("0", scale)/2, y0-text.Height(scale)), "0", scale, ink)
+	text.Draw(img, image.Pt(x0+length-text.Width(label, scale)/2, y0-text.Height(scale)), label, scale, ink)
+	text.Draw(img, image.Pt(box.Min.X+pad, y0+6*scale), scaleBarNote, scale, ink)
+}
+
+// TitleBlockSize returns the size of the title block in pixels.
+func TitleBlockSize(title string, lines []string, scale int) image.Point {
+	pad := 4 * scale
+	w, h := text.Width(title, 2*scale), text.Height(2*scale)
+	for _, line := range lines {
+		if lw := text.Width(line, scale); lw > w {
+			w = lw
+		}
+		h += text.Height(scale)
+	}
+	return image.Pt(w+2*pad, h+2*pad)
+}
+
+// TitleBlock draws the title, in large text, and the lines under it
+// with the top-left corner of the block at pt.
+func TitleBlock(img draw.Image, pt image.Point, title string, lines []string, scale int) {
+	pad := 4 * scale
+	size := TitleBlockSize(title, lines, scale)
+	fill(img, image.Rectangle{Min: pt, Max: pt.Add(size)}, panel)
+	outline(img, image.Rectangle{Min: pt, Max: pt.Add(size)}, ink)
+	text.Draw(img, pt.Add(image.Pt(pad, pad)), title, 2*scale, ink)
+	y := pt.Y + pad + text.Height(2*scale)
+	for _, line := range lines {
+		text.Draw(img, image.Pt(pt.X+pad, y), line, scale, ink)
+		y += text.Height(scale)
+	}
+}
+
+// CompassBounds returns the rectangle covered by a compass centered at pt.
+func CompassBounds(pt image.Point, scale int) image.Rectangle {
+	h, w := 24*scale, 8*scale
+	return image.Rect(pt.X-w, pt.Y-h/2-text.Height(scale), pt.X+w+1, pt.Y+h/2+1)
+}
+
+// Compass draws a north arrow centered at pt.
+func Compass(img draw.Image, pt image.Point, scale int) {
+	h, w := 24*scale, 8*scale
+	top := pt.Add(image.Pt(0, -h/2))
+	left, right, bottom := pt.Add(image.Pt(-w, h/2)), pt.Add(image.Pt(w, h/2)), pt.Add(image.Pt(0, h/4))
+	// the left half is dark and the right half is light
+	triangle(img, top, left, bottom, ink)
+	triangle(img, top, bottom, right, color.NRGBA{R: 200, G: 200, B: 200, A: 255})
+	n := image.Pt(pt.X-text.Width("N", scale)/2, top.Y-text.Height(scale))
+	text.DrawHalo(img, n, "N", scale, ink, paper)
+}
+
+// triangle fills a triangle, blending the color over the image.
+func triangle(img draw.Image, a, b, c image.Point, col color.Color) {
+	minX, maxX := min3(a.X, b.X, c.X), -min3(-a.X, -b.X, -c.X)
+	minY, maxY := min3(a.Y, b.Y, c.Y), -min3(-a.Y, -b.Y, -c.Y)
+	edge := func(p, q, x image.Point) int {
+		return (q.X-p.X)*(x.Y-p.Y) - (q.Y-p.Y)*(x.X-p.X)
+	}
+	area := edge(a, b, c)
+	if area == 0 {
+		return
+	}
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			p := image.Pt(x, y)
+			w0, w1, w2 := edge(b, c, p), edge(c, a, p), edge(a, b, p)
+			if area < 0 {
+				w0, w1, w2 = -w0, -w1, -w2
+			}
+			if w0 >= 0 && w1 >= 0 && w2 >= 0 {
+				fill(img, image.Rect(x, y, x+1, y+1), col)
+			}
+		}
+	}
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,118 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package decor
+
+import (
+	"github.com/mdhender/worldgen/pkg/text"
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// Label is a name placed on the map.
+type Label struct {
+	Text     string
+	At       image.Point // the point being labeled
+	Scale    int         // size of the text, in pixels per font pixel
+	Color    color.Color // nil for the default ink
+	Marker   bool        // mark the point and put the text beside it
+	Priority int         // labels with higher priority are placed first
+}
+
+// PlaceLabels draws as many of the labels as will fit without overlapping
+// each other or the reserved rectangles. Labels with markers are tried to
+// the right, left, above and below the marker; other labels are centered
+// on the point and then nudged up or down. Labels that don't fit are dropped.
+// It returns the number of labels drawn.
+func PlaceLabels(img draw.Image, labels []Label, reserved []image.Rectangle) int {
+	b := img.Bounds()
+	taken := append([]image.Rectangle{}, reserved...)
+	fits := func(r image.Rectangle) bool {
+		if !r.In(b) {
+			return false
+		}
+		for _, t := range taken {
+			if r.Overlaps(t) {
+				return false
+			}
+		}
+		return true
+	}
+
+	order := make([]Label, len(labels))
+	copy(order, labels)
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].Priority > order[j].Priority
+	})
+
+	placed := 0
+	for _, l := range order {
+		scale := l.Scale
+		if scale < 1 {
+			scale = 1
+		}
+		c := l.Color
+		if c == nil {
+			c = ink
+		}
+		size := text.Size(l.Text, scale)
+		// room for the halo and a little space between labels
+		pad := 2 * scale
+
+		var marker image.Rectangle
+		var candidates []image.Point
+		if l.Marker {
+			dot := scale + 1
+			marker = image.Rect(l.At.X-dot, l.At.Y-dot, l.At.X+dot+1, l.At.Y+dot+1)
+			gap := dot + 2*scale
+			candidates = []image.Point{
+				{X: l.At.X + gap, Y: l.At.Y - size.Y/2},
+				{X: l.At.X - gap - size.X, Y: l.At.Y - size.Y/2},
+				{X: l.At.X - size.X/2, Y: l.At.Y - gap - size.Y},
+				{X: l.At.X - size.X/2, Y: l.At.Y + gap},
+			}
+		} else {
+			center := l.At.Sub(size.Div(2))
+			candidates = []image.Point{
+				center,
+				center.Sub(image.Pt(0, size.Y)),
+				center.Add(image.Pt(0, size.Y)),
+			}
+		}
+
+		if l.Marker && !fits(marker.Inset(-scale)) {
+			continue
+		}
+		for _, pt := range candidates {
+			r := image.Rectangle{Min: pt, Max: pt.Add(size)}.Inset(-pad)
+			if !fits(r) {
+				continue
+			}
+			if l.Marker {
+				fill(img, marker.Inset(-scale), paper)
+				fill(img, marker, c)
+				taken = append(taken, marker.Inset(-scale))
+			}
+			text.DrawHalo(img, pt, l.Text, scale, c, paper)
+			taken = append(taken, r)
+			placed++
+			break
+		}
+	}
+	return placed
+}
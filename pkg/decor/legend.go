@@ -0,0 +1,72 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package decor
+
+import (
+	"fmt"
+	"github.com/mdhender/worldgen/pkg/cmap"
+	"image/color"
+	"math"
+)
+
+// ColorMapLegend returns a legend for a color map created from a histogram.
+// The heights don't have units, so the ticks mark the sea and ice levels.
+func ColorMapLegend(cm cmap.ColorMap, seaLevel, iceLevel int) *Legend {
+	l := &Legend{Title: "Height", Colors: cm[:]}
+	l.Ticks = append(l.Ticks, Tick{Pos: 0, Label: "lowest"})
+	l.Ticks = append(l.Ticks, Tick{Pos: float64(seaLevel) / 255, Label: "sea level"})
+	if iceLevel <= 255 {
+		l.Ticks = append(l.Ticks, Tick{Pos: float64(iceLevel) / 255, Label: "ice"})
+	}
+	l.Ticks = append(l.Ticks, Tick{Pos: 1, Label: "highest"})
+	return l
+}
+
+// ElevationLegend returns a legend for an elevation ramp, in meters,
+// covering the elevations from lo to hi. The ticks are at round numbers.
+func ElevationLegend(ramp cmap.Ramp, lo, hi float64) *Legend {
+	l := &Legend{Title: "Elevation (m)"}
+	for n := 0; n < 256; n++ {
+		l.Colors = append(l.Colors, ramp.At(lo+float64(n)*(hi-lo)/255))
+	}
+	// aim for about five ticks
+	step := math.Pow(10, math.Floor(math.Log10((hi-lo)/5)))
+	for _, f := range []float64{5, 2} {
+		if (hi-lo)/(step*f) >= 4 {
+			step *= f
+			break
+		}
+	}
+	for e := math.Ceil(lo/step) * step; e <= hi; e += step {
+		l.Ticks = append(l.Ticks, Tick{Pos: (e - lo) / (hi - lo), Label: fmt.Sprintf("%g", e)})
+	}
+	return l
+}
+
+// CodeLegend returns a legend for a list of colors, such as the color codes
+// of the original fractal program, labeling the first color in each named group.
+func CodeLegend(title string, colors []color.RGBA, groups map[int]string) *Legend {
+	l := &Legend{Title: title, Colors: colors}
+	for n, label := range groups {
+		pos := 0.0
+		if len(colors) > 1 {
+			pos = float64(n) / float64(len(colors)-1)
+		}
+		l.Ticks = append(l.Ticks, Tick{Pos: pos, Label: label})
+	}
+	return l
+}
@@ -17,46 +17,46 @@
 package fractal
 
 import (
+	"github.com/mdhender/worldgen/pkg/cmap"
+	"github.com/mdhender/worldgen/pkg/decor"
 	"image"
 	"image/color"
+	"image/draw"
 	"image/png"
 	"log"
 	"math"
 	"os"
 )
 
-var (
-	Red = [256]uint8{
-		0, 0, 0, 0, 0, 0, 0, 0, 34, 68, 102, 119, 136, 153, 170, 187,
-		0, 34, 34, 119, 187, 255, 238, 221, 204, 187, 170, 153,
-		136, 119, 85, 68,
-		255, 250, 245, 240, 235, 230, 225, 220, 215, 210, 205, 200,
-		195, 190, 185, 180, 175}
-	Green = [256]uint8{
-		0, 0, 17, 51, 85, 119, 153, 204, 221, 238, 255, 255, 255,
-		255, 255, 255, 68, 102, 136, 170, 221, 187, 170, 136,
-		136, 102, 85, 85, 68, 51, 51, 34,
-		255, 250, 245, 240, 235, 230, 225, 220, 215, 210, 205, 200,
-		195, 190, 185, 180, 175}
-	Blue = [256]uint8{
-		0, 68, 102, 136, 170, 187, 221, 255, 255, 255, 255, 255,
-		255, 255, 255, 255, 0, 0, 0, 0, 0, 34, 34, 34, 34, 34, 34,
-		34, 34, 34, 17, 0,
-		255, 250, 245, 240, 235, 230, 225, 220, 215, 210, 205, 200,
-		195, 190, 185, 180, 175}
-)
+// Red, Green and Blue are the color codes used by the original program.
+// Codes 0..15 are water, 16..31 are land and 32..48 are ice, from white down to grey.
+var Red, Green, Blue = colorCodes()
 
+// colorCodes builds the color codes from the cmap colors.
+func colorCodes() (red, green, blue [256]uint8) {
+	var colors []color.RGBA
+	colors = append(colors, cmap.Water...)
+	colors = append(colors, cmap.Terrain...)
+	for n := len(cmap.Ice) - 1; n >= 0; n-- {
+		colors = append(colors, cmap.Ice[n])
+	}
+	for n, c := range colors {
+		red[n], green[n], blue[n] = c.R, c.G, c.B
+	}
+	return red, green, blue
+}
+
+// ColorCard writes a legend of the color codes to color-card.png.
 func ColorCard(borg bool) {
-	width, height := 1280, 640
-	cardsPerRow := width / 50
-	m := image.NewRGBA(image.Rect(0, 0, width, height))
-	for x := 0; x < width; x++ {
-		c := x / cardsPerRow
-		pc := color.RGBA{R: Red[c], G: Green[c], B: Blue[c], A: 255}
-		for y := 0; y < height; y++ {
-			m.Set(x, y, pc)
-		}
+	var colors []color.RGBA
+	for c := 0; c < 49; c++ {
+		colors = append(colors, color.RGBA{R: Red[c], G: Green[c], B: Blue[c], A: 255})
 	}
+	legend := decor.CodeLegend("Color codes", colors, map[int]string{0: "0 water", 16: "16 land", 32: "32 ice"})
+	scale := 2
+	m := image.NewRGBA(image.Rectangle{Max: legend.Size(scale)})
+	draw.Draw(m, m.Bounds(), image.White, image.Point{}, draw.Src)
+	legend.Draw(m, image.Point{}, scale)
 	outFile, err := os.Create("color-card.png")
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,244 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gen
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+)
+
+// The binary format is a fixed size header, the metadata, and a zlib
+// compressed payload. All values are little-endian. The metadata is a
+// uint32 length followed by JSON (version 2 and later). The payload holds
+// the points, followed by the raw values if the header says they are present.
+const (
+	binaryMagic   = "WGMP"
+	binaryVersion = 2
+
+	// maxPoints limits the size of maps we are willing to read.
+	maxPoints = 1 << 26
+	// maxMetadata limits the size of the metadata we are willing to read.
+	maxMetadata = 1 << 20
+	// maxIterations limits the faults whose random numbers we are willing
+	// to replay when restoring the generator state.
+	maxIterations = 1 << 24
+	// maxDrawsPerFault is far more random numbers than a fault uses.
+	// Most use five or six.
+	maxDrawsPerFault = 64
+)
+
+// SampleType is the encoding of the values in the payload.
+type SampleType uint8
+
+const (
+	SampleInt16   SampleType = 1
+	SampleFloat32 SampleType = 2
+)
+
+const (
+	flagHasState uint8 = 1 << iota
+	flagHasRaw
+)
+
+type binaryHeader struct {
+	Magic      [4]byte
+	Version    uint16
+	SampleType SampleType
+	Flags      uint8
+	Height     uint32
+	Width      uint32
+	// generator parameters
+	Seed       int64
+	Draws      uint64
+	Iterations uint32
+}
+
+// WriteBinary writes the map to w using the compact binary format.
+// Values are stored as int16 when they all fit, otherwise as float32.
+func (m *Map) WriteBinary(w io.Writer) error {
+	h := binaryHeader{
+		Version:    binaryVersion,
+		SampleType: SampleInt16,
+		Height:     uint32(m.height),
+		Width:      uint32(m.width),
+	}
+	copy(h.Magic[:], binaryMagic)
+	if m.src != nil {
+		h.Flags |= flagHasState
+		h.Seed, h.Draws, h.Iterations = m.src.seed, m.src.draws, uint32(m.iterations)
+	}
+	samples := m.points
+	if m.normalized && len(m.raw) == len(m.points) {
+		h.Flags |= flagHasRaw
+		samples = append(append(make([]int, 0, 2*len(m.points)), m.points...), m.raw...)
+	}
+	for _, val := range samples {
+		if val < math.MinInt16 || val > math.MaxInt16 {
+			h.SampleType = SampleFloat32
+		}
+		if val < -1<<24 || val > 1<<24 {
+			return fmt.Errorf("binary: value %d can not be stored exactly", val)
+		}
+	}
+
+	meta, err := json.Marshal(m.Metadata())
+	if err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, &h); err != nil {
+		return err
+	} else if err = binary.Write(w, binary.LittleEndian, uint32(len(meta))); err != nil {
+		return err
+	} else if _, err = w.Write(meta); err != nil {
+		return err
+	}
+	zw := zlib.NewWriter(w)
+	switch h.SampleType {
+	case SampleInt16:
+		buf := make([]int16, len(samples))
+		for n, val := range samples {
+			buf[n] = int16(val)
+		}
+		err = binary.Write(zw, binary.LittleEndian, buf)
+	case SampleFloat32:
+		buf := make([]float32, len(samples))
+		for n, val := range samples {
+			buf[n] = float32(val)
+		}
+		err = binary.Write(zw, binary.LittleEndian, buf)
+	}
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ReadBinary reads a map that was written by WriteBinary.
+func ReadBinary(r io.Reader) (*Map, error) {
+	br := bufio.NewReader(r)
+	h, meta, err := readBinaryHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	points := int(h.Height) * int(h.Width)
+	count := points
+	if h.Flags&flagHasRaw != 0 {
+		count += points
+	}
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("binary: payload: %w", err)
+	}
+	defer zr.Close()
+	samples := make([]int, count)
+	switch h.SampleType {
+	case SampleInt16:
+		buf := make([]int16, count)
+		if err = binary.Read(zr, binary.LittleEndian, buf); err == nil {
+			for n, val := range buf {
+				samples[n] = int(val)
+			}
+		}
+	case SampleFloat32:
+		buf := make([]float32, count)
+		if err = binary.Read(zr, binary.LittleEndian, buf); err == nil {
+			for n, val := range buf {
+				samples[n] = int(val)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("binary: unknown sample type %d", h.SampleType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("binary: payload: %w", err)
+	}
+
+	m := New(int(h.Height), int(h.Width), nil)
+	copy(m.points, samples[:points])
+	if h.Flags&flagHasState != 0 {
+		m.src = newSource(h.Seed, h.Draws)
+		m.rnd = rand.New(m.src)
+		m.iterations = int(h.Iterations)
+	}
+	if h.Flags&flagHasRaw != 0 {
+		m.raw = samples[points:]
+		m.normalized = true
+	}
+	if err = m.restoreMetadata(meta); err != nil {
+		return nil, fmt.Errorf("binary: %w", err)
+	}
+	return m, nil
+}
+
+// ReadBinaryMetadata reads just the metadata of a map that was written by
+// WriteBinary, which is much faster than reading the whole map.
+func ReadBinaryMetadata(r io.Reader) (Metadata, error) {
+	h, meta, err := readBinaryHeader(bufio.NewReader(r))
+	if err != nil {
+		return Metadata{}, err
+	}
+	meta.Height, meta.Width = int(h.Height), int(h.Width)
+	meta.Iterations = int(h.Iterations)
+	meta.FormatVersion = int(h.Version)
+	return meta, nil
+}
+
+// readBinaryHeader reads and checks the header and metadata, leaving br at the start of the payload.
+func readBinaryHeader(br *bufio.Reader) (binaryHeader, Metadata, error) {
+	var h binaryHeader
+	if err := binary.Read(br, binary.LittleEndian, &h); err != nil {
+		return h, Metadata{}, fmt.Errorf("binary: header: %w", err)
+	} else if string(h.Magic[:]) != binaryMagic {
+		return h, Metadata{}, errors.New("binary: not a map file")
+	} else if h.Version < 1 || h.Version > binaryVersion {
+		return h, Metadata{}, fmt.Errorf("binary: unsupported version %d", h.Version)
+	} else if h.Height == 0 || h.Width == 0 || uint64(h.Height)*uint64(h.Width) > maxPoints {
+		return h, Metadata{}, fmt.Errorf("binary: invalid dimensions %d x %d", h.Height, h.Width)
+	} else if h.Flags&flagHasState != 0 && h.Iterations > maxIterations {
+		return h, Metadata{}, fmt.Errorf("binary: invalid iterations %d", h.Iterations)
+	} else if h.Flags&flagHasState != 0 && h.Draws > (uint64(h.Iterations)+1)*maxDrawsPerFault {
+		// the draws are replayed to restore the generator, so don't trust them
+		return h, Metadata{}, fmt.Errorf("binary: invalid draws %d for %d iterations", h.Draws, h.Iterations)
+	}
+
+	// version 1 files did not have metadata
+	var meta Metadata
+	if h.Version >= 2 {
+		var length uint32
+		if err := binary.Read(br, binary.LittleEndian, &length); err != nil {
+			return h, meta, fmt.Errorf("binary: metadata: %w", err)
+		} else if length > maxMetadata {
+			return h, meta, fmt.Errorf("binary: metadata: too large")
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return h, meta, fmt.Errorf("binary: metadata: %w", err)
+		} else if err = json.Unmarshal(data, &meta); err != nil {
+			return h, meta, fmt.Errorf("binary: metadata: %w", err)
+		}
+	}
+	return h, meta, nil
+}
@@ -0,0 +1,74 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	m := FromSeed(32, 64, 1234)
+	m.RandomFractureCircle(100)
+
+	bb := &bytes.Buffer{}
+	if err := m.WriteBinary(bb); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadBinary(bytes.NewReader(bb.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Height() != m.Height() || got.Width() != m.Width() || got.Iterations() != m.Iterations() {
+		t.Fatalf("want %dx%d after %d, got %dx%d after %d", m.Height(), m.Width(), m.Iterations(), got.Height(), got.Width(), got.Iterations())
+	}
+	for n := range m.points {
+		if got.points[n] != m.points[n] {
+			t.Fatalf("point %d: want %d, got %d", n, m.points[n], got.points[n])
+		}
+	}
+
+	// the restored generator continues where the original left off
+	if err = m.AddFaults(50); err != nil {
+		t.Fatal(err)
+	} else if err = got.AddFaults(50); err != nil {
+		t.Fatal(err)
+	}
+	for n := range m.points {
+		if got.points[n] != m.points[n] {
+			t.Fatalf("after adding faults: point %d: want %d, got %d", n, m.points[n], got.points[n])
+		}
+	}
+}
+
+func TestBinaryDraws(t *testing.T) {
+	m := FromSeed(8, 16, 1234)
+	m.RandomFractureCircle(10)
+	bb := &bytes.Buffer{}
+	if err := m.WriteBinary(bb); err != nil {
+		t.Fatal(err)
+	}
+
+	// a file claiming far more draws than its faults could use is rejected
+	// before the draws are replayed
+	data := bb.Bytes()
+	binary.LittleEndian.PutUint64(data[24:32], 1<<62)
+	if _, err := ReadBinary(bytes.NewReader(data)); err == nil {
+		t.Fatal("want error, got nil")
+	}
+}
@@ -0,0 +1,47 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gen
+
+import "github.com/mdhender/worldgen/pkg/contour"
+
+// elevations returns the un-quantized heights on the same 0..255 scale as the points.
+func (m *Map) elevations() []float32 {
+	values := m.Heights()
+	for n := range values {
+		values[n] *= 255
+	}
+	return values
+}
+
+// Contours returns the contour lines every interval units of height.
+// Heights use the same 0..255 scale as the normalized points.
+func (m *Map) Contours(interval float64) (lines []contour.Line) {
+	if interval <= 0 {
+		return nil
+	}
+	values := m.elevations()
+	for level := interval; level < 255; level += interval {
+		lines = append(lines, contour.Trace(values, m.Width(), m.Height(), level)...)
+	}
+	return lines
+}
+
+// Coastline returns the contour lines at the sea level for the percentage of water.
+// Points at or below the sea level are water.
+func (m *Map) Coastline(pctWater int) []contour.Line {
+	return contour.Trace(m.elevations(), m.Width(), m.Height(), float64(m.SeaLevel(pctWater)+1))
+}
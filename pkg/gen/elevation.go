@@ -0,0 +1,64 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gen
+
+import (
+	"fmt"
+	"github.com/mdhender/worldgen/pkg/cmap"
+	"image"
+)
+
+// ElevationRange scales the heights of a map to meters.
+// The lowest point of the map is at Min and the highest is at Max.
+// Sea level is zero, so points below zero are under water.
+type ElevationRange struct {
+	Min, Max float64
+}
+
+// DefaultElevationRange is close to the range of the Earth,
+// from the bottom of the deepest trench to the top of the highest peak.
+func DefaultElevationRange() ElevationRange {
+	return ElevationRange{Min: -11000, Max: 9000}
+}
+
+func (er ElevationRange) Validate() error {
+	if er.Min >= er.Max {
+		return fmt.Errorf("elevation: minimum %g must be below maximum %g", er.Min, er.Max)
+	}
+	return nil
+}
+
+// Elevations returns the elevation of each point in meters, in row order.
+func (m *Map) Elevations(er ElevationRange) []float32 {
+	values := m.Heights()
+	for n, h := range values {
+		values[n] = float32(er.Min + float64(h)*(er.Max-er.Min))
+	}
+	return values
+}
+
+// AsElevationMap colors the map by elevation in meters rather than by
+// percentage of water and ice, so the same color is the same elevation
+// on every map. The ramp positions are meters, like cmap.Hypsometric.
+func (m *Map) AsElevationMap(er ElevationRange, tints cmap.Ramp) *image.RGBA {
+	height, width := m.Height(), m.Width()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for n, e := range m.Elevations(er) {
+		img.SetRGBA(n%width, n/width, tints.At(float64(e)))
+	}
+	return img
+}
@@ -19,19 +19,31 @@ package gen
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"image"
 	"image/png"
 	"log"
 	"math"
 	"math/rand"
+	"time"
 )
 
 type Map struct {
 	height, width int
 	diagonal      float64
 	rnd           *rand.Rand
+	src           *source // nil if the caller provided the generator
+	iterations    int     // number of faults applied to the map
+	normalized    bool
+	raw           []int // points before they were normalized
+	shiftX        int   // number of columns the map has been shifted
+	shiftY        int   // number of rows the map has been shifted
+	meta          Metadata
 	points        []int
-	yx            [][]int // points indexed by y, x
+	yx            [][]int               // points indexed by y, x
+	progress      func(done, total int) // optional, called while faults are added
 }
 
 func New(height, width int, rnd *rand.Rand) *Map {
@@ -42,6 +54,10 @@ func New(height, width int, rnd *rand.Rand) *Map {
 		rnd:      rnd,
 		points:   make([]int, height*width, height*width),
 		yx:       make([][]int, height),
+		meta: Metadata{
+			Created: time.Now().UTC(),
+			Version: codeVersion(),
+		},
 	}
 	for row := 0; row < height; row++ {
 		m.yx[row] = m.points[row*width : (row+1)*width]
@@ -49,10 +65,56 @@ func New(height, width int, rnd *rand.Rand) *Map {
 	return m
 }
 
-func (m *Map) AsPNG(img *image.RGBA) ([]byte, error) {
+// FromSeed returns a new map with a generator that can be saved and
+// restored, allowing more faults to be added to the map later.
+func FromSeed(height, width int, seed int64) *Map {
+	src := newSource(seed, 0)
+	m := New(height, width, rand.New(src))
+	m.src = src
+	m.meta.Seed = fmt.Sprintf("%x", uint64(seed))
+	return m
+}
+
+// AddFaults continues generating the map from where it stopped.
+// If the map has been normalized, the faults are applied to the raw
+// values and the map is normalized again.
+// The result is the same as if the map had been generated with all
+// the faults in the first place. Any shifts applied to the map are lost.
+func (m *Map) AddFaults(n int) error {
+	return m.AddFaultsContext(context.Background(), n)
+}
+
+// AddFaultsContext is like AddFaults but stops between faults if the context
+// is done, returning the context's error. The map is left partly updated and
+// should be discarded.
+func (m *Map) AddFaultsContext(ctx context.Context, n int) error {
+	if m.src == nil {
+		return errors.New("map has no generator state")
+	}
+	if m.normalized {
+		if len(m.raw) != len(m.points) {
+			return errors.New("map has no raw values")
+		}
+		copy(m.points, m.raw)
+		m.normalized = false
+		// the raw values were never shifted
+		m.shiftX, m.shiftY = 0, 0
+	}
+	if err := m.RandomFractureCircleContext(ctx, n); err != nil {
+		return err
+	}
+	m.Normalize()
+	return nil
+}
+
+// AsPNG encodes the image as a PNG.
+// The map's metadata is added as text chunks.
+func (m *Map) AsPNG(img image.Image) ([]byte, error) {
 	bb := &bytes.Buffer{}
-	err := png.Encode(bb, img)
-	return bb.Bytes(), err
+	if err := png.Encode(bb, img); err != nil {
+		return nil, err
+	}
+	return insertPNGText(bb.Bytes(), m.pngText())
 }
 
 func (m *Map) Diagonal() float64 {
@@ -63,6 +125,11 @@ func (m *Map) Height() int {
 	return m.height
 }
 
+// Iterations returns the number of faults that have been applied to the map.
+func (m *Map) Iterations() int {
+	return m.iterations
+}
+
 func (m *Map) Width() int {
 	return m.width
 }
@@ -143,8 +210,17 @@ func FractureSlice(bump float64, world [][]float64) {
 	}
 }
 
-// Normalize the values in the map to the range of 0..1
+// Normalize the values in the map to the range of 0..255.
+// The raw values are kept so that faults can be added later.
 func (m *Map) Normalize() {
+	if m.normalized {
+		return
+	}
+	m.normalized = true
+	m.raw = append(m.raw[:0], m.points...)
+	m.meta.Normalization = "linear 0..255"
+	defer m.updateHash()
+
 	// fetch the minimum value in the set of points
 	minValue, maxValue := m.points[0], m.points[0]
 	for _, val := range m.points {
@@ -190,8 +266,29 @@ func (m *Map) Normalize() {
 	log.Printf("normalize: min %8d max %8d\n", minValue, maxValue)
 }
 
+// OnProgress sets a function that is called as faults are added to the map,
+// about once for every percent of the faults and again when they are done.
+func (m *Map) OnProgress(fn func(done, total int)) {
+	m.progress = fn
+}
+
 func (m *Map) RandomFractureCircle(n int) {
+	_ = m.RandomFractureCircleContext(context.Background(), n)
+}
+
+// RandomFractureCircleContext is like RandomFractureCircle but stops between
+// faults if the context is done, returning the context's error. The faults
+// added before it stopped are kept.
+func (m *Map) RandomFractureCircleContext(ctx context.Context, n int) error {
+	m.meta.Generator = "fracture-circle"
+	total, every := n, n/100
+	if every < 1 {
+		every = 1
+	}
 	for n > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		// decide the amount that we're going to raise or lower
 		switch m.rnd.Intn(2) {
 		case 0:
@@ -199,8 +296,13 @@ func (m *Map) RandomFractureCircle(n int) {
 		case 1:
 			m.FractureCircle(-1)
 		}
+		m.iterations++
 		n--
+		if m.progress != nil && (n == 0 || (total-n)%every == 0) {
+			m.progress(total-n, total)
+		}
 	}
+	return nil
 }
 
 func (m *Map) ShiftX(dx int) {
@@ -222,6 +324,7 @@ func (m *Map) ShiftX(dx int) {
 		copy(m.yx[y][dx:], m.yx[y])
 		copy(m.yx[y], tmp)
 	}
+	m.shiftX = (m.shiftX + dx) % width
 }
 
 func (m *Map) ShiftY(dy int) {
@@ -237,9 +340,11 @@ func (m *Map) ShiftY(dy int) {
 	if dy == 0 {
 		return
 	}
-	//shiftY(m.yx, dy)
-	tmp := make([][]int, dy)
-	copy(tmp, m.yx[height-dy:])
-	copy(m.yx[dy:], m.yx)
-	copy(m.yx, tmp)
+	// move the points rather than the rows so that the points stay in row order
+	width := m.Width()
+	tmp := make([]int, dy*width)
+	copy(tmp, m.points[(height-dy)*width:])
+	copy(m.points[dy*width:], m.points)
+	copy(m.points, tmp)
+	m.shiftY = (m.shiftY + dy) % height
 }
@@ -0,0 +1,84 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gen
+
+import (
+	"github.com/mdhender/worldgen/pkg/geojson"
+	"math"
+)
+
+// AsGeoJSON returns the regions of the map as polygons and the coastline as lines,
+// in longitude and latitude. Only the layers in the list are included; the layers
+// are "land" (continents and islands), "water" (oceans and lakes), "ice" and "coastline".
+// Polygons that cross the antimeridian are split into a polygon on each side.
+func (m *Map) AsGeoJSON(pctWater, pctIce int, layers ...string) *geojson.FeatureCollection {
+	want := map[string]bool{}
+	for _, layer := range layers {
+		want[layer] = true
+	}
+	layerOf := map[RegionKind]string{Continent: "land", Island: "land", Ocean: "water", Lake: "water", IceCap: "ice"}
+
+	fc := geojson.NewFeatureCollection()
+	r := m.Regions(pctWater, pctIce)
+	for _, rg := range r.List {
+		if !want[layerOf[rg.Kind]] {
+			continue
+		}
+		ids := r.surface
+		if rg.Kind == IceCap {
+			ids = r.ice
+		}
+		polygons := geojson.Polygonize(m.width, m.height, rg.bounds, func(x, y int) bool {
+			return ids[y*m.width+x] == rg.ID
+		})
+		f := geojson.NewFeature(geojson.NewMultiPolygon(polygons))
+		f.ID = rg.ID
+		f.Properties["kind"] = rg.Kind
+		f.Properties["label"] = rg.Label
+		f.Properties["layer"] = layerOf[rg.Kind]
+		f.Properties["points"] = rg.Points
+		f.Properties["area_pct"] = math.Round(rg.Area*1e6) / 1e4
+		f.Properties["area_km2"] = math.Round(rg.Area * 4 * math.Pi * EarthRadius * EarthRadius)
+		f.Properties["max_elevation"] = rg.MaxElevation
+		f.Properties["peak"] = geojson.LonLat(float64(rg.Peak.X)+0.5, float64(rg.Peak.Y)+0.5, m.width, m.height)
+		fc.Features = append(fc.Features, f)
+	}
+
+	if want["coastline"] {
+		var lines [][]geojson.Position
+		for _, line := range m.Coastline(pctWater) {
+			for _, part := range line.Split(m.width) {
+				var positions []geojson.Position
+				for _, p := range part {
+					// the contour points are at the centers of the map points
+					x := math.Max(0, math.Min(float64(m.width), p.X+0.5))
+					positions = append(positions, geojson.LonLat(x, p.Y+0.5, m.width, m.height))
+				}
+				if len(positions) > 1 {
+					lines = append(lines, positions)
+				}
+			}
+		}
+		f := geojson.NewFeature(geojson.NewMultiLineString(lines))
+		f.Properties["kind"] = "coastline"
+		f.Properties["layer"] = "coastline"
+		f.Properties["sea_level"] = r.SeaLevel
+		fc.Features = append(fc.Features, f)
+	}
+
+	return fc
+}
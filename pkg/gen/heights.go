@@ -0,0 +1,109 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gen
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/mdhender/worldgen/pkg/geotiff"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// rawAt returns the un-normalized value at x, y.
+// The raw values are never shifted, so we undo the shifts to find the point.
+// If the map has no raw values, the points are used instead.
+func (m *Map) rawAt(x, y int) int {
+	if !m.normalized || len(m.raw) != len(m.points) {
+		return m.yx[y][x]
+	}
+	x, y = (x-m.shiftX+m.width)%m.width, (y-m.shiftY+m.height)%m.height
+	return m.raw[y*m.width+x]
+}
+
+// Heights returns the un-quantized heights of the map scaled to 0..1.
+// The heights are in row order and include any shifts.
+func (m *Map) Heights() []float32 {
+	height, width := m.Height(), m.Width()
+	minValue, maxValue := math.MaxInt, math.MinInt
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			val := m.rawAt(x, y)
+			if val < minValue {
+				minValue = val
+			}
+			if maxValue < val {
+				maxValue = val
+			}
+		}
+	}
+	deltaValue := float64(maxValue - minValue)
+	if deltaValue == 0 {
+		// avoid dividing by zero on a flat map
+		deltaValue = 1
+	}
+
+	heights := make([]float32, 0, height*width)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			heights = append(heights, float32(float64(m.rawAt(x, y)-minValue)/deltaValue))
+		}
+	}
+	return heights
+}
+
+// AsGreyscale16 returns a 16-bit image of the un-quantized heights.
+func (m *Map) AsGreyscale16() *image.Gray16 {
+	height, width := m.Height(), m.Width()
+	img := image.NewGray16(image.Rect(0, 0, width, height))
+	for n, val := range m.Heights() {
+		img.SetGray16(n%width, n/width, color.Gray16{Y: uint16(math.Round(float64(val) * 65535))})
+	}
+	return img
+}
+
+// WriteRawFloat32 writes the heights as little-endian float32 values in the range 0..1.
+// There is no header; the reader must know the height and width of the map.
+func (m *Map) WriteRawFloat32(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if err := binary.Write(bw, binary.LittleEndian, m.Heights()); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// WriteRawUint16 writes the heights as little-endian uint16 values in the range 0..65535.
+// There is no header; the reader must know the height and width of the map.
+func (m *Map) WriteRawUint16(w io.Writer) error {
+	heights := m.Heights()
+	buf := make([]uint16, len(heights))
+	for n, val := range heights {
+		buf[n] = uint16(math.Round(float64(val) * 65535))
+	}
+	bw := bufio.NewWriter(w)
+	if err := binary.Write(bw, binary.LittleEndian, buf); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// WriteGeoTIFF writes the heights as a float32 GeoTIFF covering the globe.
+func (m *Map) WriteGeoTIFF(w io.Writer) error {
+	return geotiff.Encode(w, m.Width(), m.Height(), m.Heights())
+}
@@ -24,8 +24,24 @@ func (m *Map) Histogram() (hs [256]int) {
 	return hs
 }
 
+// IceLevel returns the lowest height covered by ice when pct percent
+// of the points, starting with the highest, are ice.
+// Points at or above the ice level are ice. If there is no ice, it returns 256.
 func (m *Map) IceLevel(pct int) int {
-	return 200
+	threshold := pct * len(m.points) / 100
+	if threshold < 1 {
+		return 256
+	}
+
+	// find the ice-level
+	pixels, hs := 0, m.Histogram()
+	for n := len(hs) - 1; n > 0; n-- {
+		if pixels += hs[n]; pixels >= threshold {
+			return n
+		}
+	}
+
+	return 1
 }
 
 func (m *Map) SeaLevel(pct int) int {
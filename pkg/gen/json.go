@@ -19,12 +19,23 @@ package gen
 import (
 	"encoding/json"
 	"math"
+	"math/rand"
 )
 
 type mapJS struct {
-	Height int   `json:"height"`
-	Width  int   `json:"width"`
-	Points []int `json:"points"`
+	Height   int       `json:"height"`
+	Width    int       `json:"width"`
+	Points   []int     `json:"points"`
+	State    *stateJS  `json:"state,omitempty"`
+	Metadata *Metadata `json:"metadata,omitempty"`
+}
+
+// stateJS is the generator state needed to add more faults to a saved map.
+type stateJS struct {
+	Seed       int64  `json:"seed"`
+	Draws      uint64 `json:"draws"`
+	Iterations int    `json:"iterations"`
+	Raw        []int  `json:"raw,omitempty"`
 }
 
 func (m *Map) MarshalJSON() ([]byte, error) {
@@ -33,6 +44,18 @@ func (m *Map) MarshalJSON() ([]byte, error) {
 		Width:  m.Width(),
 		Points: m.points,
 	}
+	md := m.Metadata()
+	a.Metadata = &md
+	if m.src != nil {
+		a.State = &stateJS{
+			Seed:       m.src.seed,
+			Draws:      m.src.draws,
+			Iterations: m.iterations,
+		}
+		if m.normalized {
+			a.State.Raw = m.raw
+		}
+	}
 	return json.Marshal(&a)
 }
 
@@ -50,9 +73,23 @@ func (m *Map) UnmarshalJSON(data []byte) error {
 	for row := 0; row < m.height; row++ {
 		m.yx[row] = m.points[row*m.width : (row+1)*m.width]
 	}
+	if a.State != nil {
+		m.src = newSource(a.State.Seed, a.State.Draws)
+		m.rnd = rand.New(m.src)
+		m.iterations = a.State.Iterations
+		m.raw = a.State.Raw
+		m.normalized = len(m.raw) == len(m.points)
+	}
+	var md Metadata
+	if a.Metadata != nil {
+		md = *a.Metadata
+	}
+	if err := m.restoreMetadata(md); err != nil {
+		return err
+	}
 
 	// keep the local from leaking?
-	a.Points = nil
+	a.Points, a.State = nil, nil
 
 	return nil
 }
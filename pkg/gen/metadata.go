@@ -0,0 +1,131 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gen
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Metadata records how a map was created so that it can be audited and regenerated.
+type Metadata struct {
+	Generator     string    `json:"generator,omitempty"`
+	Seed          string    `json:"seed,omitempty"` // hex, same as the web form
+	Height        int       `json:"height"`
+	Width         int       `json:"width"`
+	Iterations    int       `json:"iterations"`
+	Normalization string    `json:"normalization,omitempty"`
+	ShiftX        int       `json:"shift_x,omitempty"`
+	ShiftY        int       `json:"shift_y,omitempty"`
+	Version       string    `json:"version,omitempty"` // version of the code that created the map
+	FormatVersion int       `json:"format_version"`
+	Created       time.Time `json:"created"`
+	Hash          string    `json:"hash,omitempty"` // sha256 of the unshifted points
+}
+
+// Metadata returns the metadata for the map.
+func (m *Map) Metadata() Metadata {
+	md := m.meta
+	md.Height, md.Width = m.height, m.width
+	md.Iterations = m.iterations
+	md.ShiftX, md.ShiftY = m.shiftX, m.shiftY
+	md.FormatVersion = binaryVersion
+	return md
+}
+
+// restoreMetadata restores the metadata of a map that has just been loaded.
+// It returns an error if the points don't match the saved hash.
+func (m *Map) restoreMetadata(md Metadata) error {
+	m.meta = Metadata{
+		Generator:     md.Generator,
+		Seed:          md.Seed,
+		Normalization: md.Normalization,
+		Version:       md.Version,
+		Created:       md.Created,
+		Hash:          md.Hash,
+	}
+	// the hash is for the unshifted points, so we can only check it if
+	// the points were saved without being shifted.
+	m.shiftX, m.shiftY = md.ShiftX, md.ShiftY
+	if m.shiftX == 0 && m.shiftY == 0 {
+		hash := contentHash(m.points)
+		if md.Hash != "" && md.Hash != hash {
+			return errors.New("content hash does not match metadata")
+		}
+		m.meta.Hash = hash
+	}
+	return nil
+}
+
+// updateHash saves the hash of the points.
+// It must be called before the map is shifted.
+func (m *Map) updateHash() {
+	m.meta.Hash = contentHash(m.points)
+}
+
+// contentHash returns the sha256 of the points, stored as little-endian int32.
+func contentHash(points []int) string {
+	h := sha256.New()
+	buf := make([]byte, 4*1024)
+	for len(points) != 0 {
+		n := len(buf) / 4
+		if n > len(points) {
+			n = len(points)
+		}
+		for i, val := range points[:n] {
+			binary.LittleEndian.PutUint32(buf[i*4:], uint32(int32(val)))
+		}
+		h.Write(buf[:n*4])
+		points = points[n:]
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+var (
+	versionOnce sync.Once
+	version     string
+)
+
+// Version returns the version of the code, as recorded in the metadata of new maps.
+func Version() string {
+	return codeVersion()
+}
+
+// codeVersion returns the module version and, if available, the commit it was built from.
+func codeVersion() string {
+	versionOnce.Do(func() {
+		version = "(devel)"
+		bi, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		if bi.Main.Version != "" {
+			version = bi.Main.Version
+		}
+		for _, setting := range bi.Settings {
+			if setting.Key == "vcs.revision" {
+				version += "+" + setting.Value
+			}
+		}
+	})
+	return version
+}
@@ -0,0 +1,65 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gen
+
+import (
+	"image"
+	"sort"
+)
+
+// Peaks returns up to n of the highest points of the map, highest first.
+// Each peak is at least spacing points from the others, so a single
+// mountain range doesn't take every spot.
+func (m *Map) Peaks(n, spacing int) []image.Point {
+	if n <= 0 {
+		return nil
+	}
+	heights := m.Heights()
+	order := make([]int, len(heights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return heights[order[i]] > heights[order[j]]
+	})
+
+	var peaks []image.Point
+	for _, i := range order {
+		p := image.Pt(i%m.width, i/m.width)
+		crowded := false
+		for _, q := range peaks {
+			// the map wraps east to west
+			dx, dy := p.X-q.X, p.Y-q.Y
+			if dx < 0 {
+				dx = -dx
+			}
+			if m.width-dx < dx {
+				dx = m.width - dx
+			}
+			if dx*dx+dy*dy < spacing*spacing {
+				crowded = true
+				break
+			}
+		}
+		if !crowded {
+			if peaks = append(peaks, p); len(peaks) == n {
+				break
+			}
+		}
+	}
+	return peaks
+}
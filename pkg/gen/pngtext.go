@@ -0,0 +1,71 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+)
+
+// rfc1123 is the layout the PNG specification suggests for the creation time.
+const rfc1123 = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// pngText returns the text chunks that we add to every PNG.
+// The keywords are the standard ones from the PNG specification,
+// plus "worldgen" which holds the metadata as JSON.
+func (m *Map) pngText() [][2]string {
+	md := m.Metadata()
+	text := [][2]string{{"Software", "worldgen " + codeVersion()}}
+	if md.Generator != "" {
+		text = append(text, [2]string{"Source", md.Generator})
+	}
+	if !md.Created.IsZero() {
+		text = append(text, [2]string{"Creation Time", md.Created.UTC().Format(rfc1123)})
+	}
+	if data, err := json.Marshal(md); err == nil {
+		text = append(text, [2]string{"worldgen", string(data)})
+	}
+	return text
+}
+
+// insertPNGText adds tEXt chunks to an encoded PNG.
+// The chunks are placed right after the IHDR chunk.
+func insertPNGText(data []byte, text [][2]string) ([]byte, error) {
+	// the signature is 8 bytes and the IHDR chunk is 25 bytes
+	const ihdrEnd = 8 + 25
+	if len(data) < ihdrEnd || !bytes.Equal(data[12:16], []byte("IHDR")) {
+		return nil, errors.New("png: missing IHDR chunk")
+	}
+
+	bb := &bytes.Buffer{}
+	bb.Write(data[:ihdrEnd])
+	for _, kv := range text {
+		chunk := make([]byte, 0, 4+len(kv[0])+1+len(kv[1]))
+		chunk = append(chunk, "tEXt"...)
+		chunk = append(chunk, kv[0]...)
+		chunk = append(chunk, 0)
+		chunk = append(chunk, kv[1]...)
+		_ = binary.Write(bb, binary.BigEndian, uint32(len(chunk)-4))
+		bb.Write(chunk)
+		_ = binary.Write(bb, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	}
+	bb.Write(data[ihdrEnd:])
+	return bb.Bytes(), nil
+}
@@ -0,0 +1,103 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gen
+
+import (
+	"fmt"
+	"image"
+	"sort"
+)
+
+// Ranges returns the mountain ranges of the map, largest first. A range is
+// a connected area of land where every point is among the highest pctHigh
+// percent of the land. Areas smaller than minArea, a fraction of the globe,
+// are lone mountains rather than ranges and are left out.
+func (m *Map) Ranges(seaLevel, pctHigh int, minArea float64) []*Region {
+	var land []int
+	for _, h := range m.points {
+		if h > seaLevel {
+			land = append(land, h)
+		}
+	}
+	if len(land) == 0 || pctHigh <= 0 {
+		return nil
+	}
+	sort.Ints(land)
+	n := len(land) * (100 - pctHigh) / 100
+	if n >= len(land) {
+		n = len(land) - 1
+	}
+	level := land[n]
+	isHigh := func(n int) bool { return m.points[n] > seaLevel && m.points[n] >= level }
+
+	ids := make([]int, len(m.points))
+	rowArea := m.rowAreas()
+	var list []*Region
+	for start := range m.points {
+		if ids[start] != 0 || !isHigh(start) {
+			continue
+		}
+		rg := &Region{ID: len(list) + 1, Kind: Range, MaxElevation: -1}
+		ids[start] = rg.ID
+		stack := []int{start}
+		for len(stack) != 0 {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := n%m.width, n/m.width
+			rg.Points++
+			rg.Area += rowArea[y]
+			if m.points[n] > rg.MaxElevation {
+				rg.MaxElevation, rg.Peak = m.points[n], image.Pt(x, y)
+			}
+			neighbors := [4]int{y*m.width + (x+1)%m.width, y*m.width + (x+m.width-1)%m.width, -1, -1}
+			if y > 0 {
+				neighbors[2] = n - m.width
+			}
+			if y+1 < m.height {
+				neighbors[3] = n + m.width
+			}
+			for _, nb := range neighbors {
+				if nb != -1 && ids[nb] == 0 && isHigh(nb) {
+					ids[nb] = rg.ID
+					stack = append(stack, nb)
+				}
+			}
+		}
+		list = append(list, rg)
+	}
+
+	// keep the large areas and number them largest first
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Area > list[j].Area
+	})
+	renumber := make([]int, len(list)+1)
+	var ranges []*Region
+	for _, rg := range list {
+		if rg.Area < minArea {
+			break
+		}
+		renumber[rg.ID], rg.ID = len(ranges)+1, len(ranges)+1
+		rg.Label = fmt.Sprintf("%s %d", kindLabels[Range], rg.ID)
+		ranges = append(ranges, rg)
+	}
+	for n := range ids {
+		ids[n] = renumber[ids[n]]
+	}
+	r := &Regions{Width: m.width, Height: m.height, List: ranges}
+	r.findCenters(ids)
+	return ranges
+}
@@ -0,0 +1,52 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gen
+
+import "testing"
+
+func TestRanges(t *testing.T) {
+	// land everywhere, with two high areas and one high point
+	m := New(20, 40, nil)
+	for n := range m.points {
+		m.points[n] = 10
+	}
+	set := func(x0, y0, x1, y1, h int) {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				m.points[y*m.width+x] = h
+			}
+		}
+	}
+	set(2, 5, 12, 10, 50)   // 50 points
+	set(38, 12, 40, 15, 60) // wraps around to the next one
+	set(0, 12, 5, 15, 60)   // 21 points together
+	set(25, 2, 26, 3, 90)   // a lone mountain
+
+	ranges := m.Ranges(0, 5, 10*m.rowAreas()[10])
+	if len(ranges) != 2 {
+		t.Fatalf("want 2 ranges, got %d", len(ranges))
+	}
+	if ranges[0].Points != 50 || ranges[1].Points != 21 {
+		t.Errorf("want 50 and 21 points, got %d and %d", ranges[0].Points, ranges[1].Points)
+	}
+	if ranges[1].MaxElevation != 60 || ranges[1].Label != "Range 2" {
+		t.Errorf("want Range 2 at 60, got %s at %d", ranges[1].Label, ranges[1].MaxElevation)
+	}
+	if c := ranges[0].Center; c.X < 2 || c.X >= 12 || c.Y < 5 || c.Y >= 10 {
+		t.Errorf("want the center of the first range inside it, got %v", c)
+	}
+}
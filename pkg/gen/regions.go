@@ -0,0 +1,253 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gen
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+// EarthRadius is the radius, in kilometers, used when converting areas
+// and distances on the map into real units.
+const EarthRadius = 6371.0
+
+type RegionKind string
+
+const (
+	Ocean     RegionKind = "ocean"
+	Lake      RegionKind = "lake"
+	Continent RegionKind = "continent"
+	Island    RegionKind = "island"
+	IceCap    RegionKind = "ice"
+	Range     RegionKind = "range" // mountains, from Ranges
+)
+
+// regions smaller than this fraction of the globe are lakes or islands
+const minMajorRegion = 0.01
+
+// Region is a connected area of water, land, or ice.
+// Regions wrap around the east and west edges of the map.
+type Region struct {
+	ID           int
+	Kind         RegionKind
+	Label        string // generic label, such as "Continent 1"
+	Name         string // place name, empty until the region is named
+	Points       int
+	Area         float64     // fraction of the surface of the globe
+	MaxElevation int         // highest point, 0..255
+	Peak         image.Point // location of the highest point
+	Center       image.Point // the point farthest from the edge of the region, for labels
+	bounds       image.Rectangle
+}
+
+// Regions is the result of dividing a map into regions.
+type Regions struct {
+	Width, Height int
+	SeaLevel      int
+	IceLevel      int
+	List          []*Region // sorted by kind and then by area, largest first
+	surface       []int     // id of the water or land region for each point
+	ice           []int     // id of the ice region for each point, or 0
+}
+
+// Regions divides the map into oceans, lakes, continents, islands and ice caps.
+// Points at or below the sea level are water and points at or above the ice level are ice.
+// Ice caps are on top of the land, so every point is in exactly one water or land region.
+func (m *Map) Regions(pctWater, pctIce int) *Regions {
+	r := &Regions{
+		Width:    m.width,
+		Height:   m.height,
+		SeaLevel: m.SeaLevel(pctWater),
+		IceLevel: m.IceLevel(pctIce),
+		surface:  make([]int, len(m.points)),
+		ice:      make([]int, len(m.points)),
+	}
+	isWater := func(n int) bool { return m.points[n] <= r.SeaLevel }
+	isIce := func(n int) bool { return m.points[n] > r.SeaLevel && m.points[n] >= r.IceLevel }
+
+	rowArea := m.rowAreas()
+
+	// fill assigns id to every point connected to start that is like it.
+	fill := func(ids []int, start, id int, like func(int) bool) *Region {
+		rg := &Region{ID: id, MaxElevation: -1, bounds: image.Rect(start%m.width, start/m.width, start%m.width+1, start/m.width+1)}
+		ids[start] = id
+		stack := []int{start}
+		for len(stack) != 0 {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := n%m.width, n/m.width
+			rg.Points++
+			rg.Area += rowArea[y]
+			rg.bounds = rg.bounds.Union(image.Rect(x, y, x+1, y+1))
+			if m.points[n] > rg.MaxElevation {
+				rg.MaxElevation, rg.Peak = m.points[n], image.Pt(x, y)
+			}
+			neighbors := [4]int{y*m.width + (x+1)%m.width, y*m.width + (x+m.width-1)%m.width, -1, -1}
+			if y > 0 {
+				neighbors[2] = n - m.width
+			}
+			if y+1 < m.height {
+				neighbors[3] = n + m.width
+			}
+			for _, nb := range neighbors {
+				if nb != -1 && ids[nb] == 0 && like(nb) {
+					ids[nb] = id
+					stack = append(stack, nb)
+				}
+			}
+		}
+		return rg
+	}
+
+	for n := range m.points {
+		if r.surface[n] == 0 {
+			var rg *Region
+			if isWater(n) {
+				rg = fill(r.surface, n, len(r.List)+1, isWater)
+				rg.Kind = Lake
+				if rg.Area >= minMajorRegion {
+					rg.Kind = Ocean
+				}
+			} else {
+				rg = fill(r.surface, n, len(r.List)+1, func(n int) bool { return !isWater(n) })
+				rg.Kind = Island
+				if rg.Area >= minMajorRegion {
+					rg.Kind = Continent
+				}
+			}
+			r.List = append(r.List, rg)
+		}
+		if r.ice[n] == 0 && isIce(n) {
+			rg := fill(r.ice, n, len(r.List)+1, isIce)
+			rg.Kind = IceCap
+			r.List = append(r.List, rg)
+		}
+	}
+
+	// number the regions in order and label them
+	order := map[RegionKind]int{Continent: 0, Island: 1, Ocean: 2, Lake: 3, IceCap: 4}
+	sort.SliceStable(r.List, func(i, j int) bool {
+		if a, b := order[r.List[i].Kind], order[r.List[j].Kind]; a != b {
+			return a < b
+		}
+		return r.List[i].Area > r.List[j].Area
+	})
+	renumber := make([]int, len(r.List)+1)
+	count := map[RegionKind]int{}
+	for n, rg := range r.List {
+		renumber[rg.ID], rg.ID = n+1, n+1
+		count[rg.Kind]++
+		rg.Label = fmt.Sprintf("%s %d", kindLabels[rg.Kind], count[rg.Kind])
+	}
+	for n := range r.surface {
+		r.surface[n] = renumber[r.surface[n]]
+		r.ice[n] = renumber[r.ice[n]]
+	}
+	r.findCenters(r.surface)
+	r.findCenters(r.ice)
+
+	return r
+}
+
+// findCenters sets the center of each region in ids to the point farthest from its edge.
+// The distances are approximated with a two-pass chamfer distance transform. The
+// passes are repeated so that distances carry around the east and west edges.
+func (r *Regions) findCenters(ids []int) {
+	width, height := r.Width, r.Height
+	const straight, diagonal = 3, 4
+	dist := make([]int, len(ids))
+	for n, id := range ids {
+		x, y := n%width, n/width
+		dist[n] = math.MaxInt32
+		// the poles count as edges since labels there would be cut off
+		if y == 0 || y+1 == height || ids[n-width] != id || ids[n+width] != id ||
+			ids[y*width+(x+1)%width] != id || ids[y*width+(x+width-1)%width] != id {
+			dist[n] = 0
+		}
+	}
+	relax := func(n, x, y, dx, dy, cost int) {
+		if y+dy < 0 || y+dy >= height {
+			return
+		}
+		if d := dist[(y+dy)*width+(x+dx+width)%width] + cost; d < dist[n] {
+			dist[n] = d
+		}
+	}
+	for pass := 0; pass < 2; pass++ {
+		for n := 0; n < len(ids); n++ {
+			x, y := n%width, n/width
+			relax(n, x, y, -1, 0, straight)
+			relax(n, x, y, -1, -1, diagonal)
+			relax(n, x, y, 0, -1, straight)
+			relax(n, x, y, 1, -1, diagonal)
+		}
+		for n := len(ids) - 1; n >= 0; n-- {
+			x, y := n%width, n/width
+			relax(n, x, y, 1, 0, straight)
+			relax(n, x, y, 1, 1, diagonal)
+			relax(n, x, y, 0, 1, straight)
+			relax(n, x, y, -1, 1, diagonal)
+		}
+	}
+
+	best := make([]int, len(r.List)+1)
+	for n := range best {
+		best[n] = -1
+	}
+	for n, id := range ids {
+		if id != 0 && dist[n] > best[id] {
+			best[id] = dist[n]
+			r.List[id-1].Center = image.Pt(n%width, n/width)
+		}
+	}
+}
+
+// rowAreas returns the fraction of the globe covered by a point in each row.
+func (m *Map) rowAreas() []float64 {
+	rowArea := make([]float64, m.height)
+	for y := range rowArea {
+		lat0 := math.Pi/2 - float64(y)*math.Pi/float64(m.height)
+		lat1 := math.Pi/2 - float64(y+1)*math.Pi/float64(m.height)
+		rowArea[y] = (math.Sin(lat0) - math.Sin(lat1)) / 2 / float64(m.width)
+	}
+	return rowArea
+}
+
+var kindLabels = map[RegionKind]string{
+	Ocean:     "Ocean",
+	Lake:      "Lake",
+	Continent: "Continent",
+	Island:    "Island",
+	IceCap:    "Ice Cap",
+	Range:     "Range",
+}
+
+// Region returns the region with the given id, or nil if there isn't one.
+func (r *Regions) Region(id int) *Region {
+	if id < 1 || id > len(r.List) {
+		return nil
+	}
+	return r.List[id-1]
+}
+
+// At returns the water or land region and the ice region (which may be nil) at x, y.
+func (r *Regions) At(x, y int) (surface, ice *Region) {
+	n := y*r.Width + x
+	return r.Region(r.surface[n]), r.Region(r.ice[n])
+}
@@ -0,0 +1,130 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gen
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// reliefScale is the difference between the lowest and highest points of the
+// map as a fraction of the width of the map. That is 20 km on an Earth-sized world.
+const reliefScale = 1.0 / 2000
+
+// ShadeOptions controls the shading created by Shade.
+type ShadeOptions struct {
+	Azimuth      float64 // direction of the sun, in degrees clockwise from north
+	Altitude     float64 // angle of the sun above the horizon, in degrees
+	Exaggeration float64 // vertical exaggeration
+	Slope        bool    // shade by steepness instead of by the direction of the sun
+	Strength     float64 // how much the shading darkens a color map, 0..1
+}
+
+// DefaultShadeOptions returns the traditional north-west light.
+func DefaultShadeOptions() ShadeOptions {
+	return ShadeOptions{Azimuth: 315, Altitude: 45, Exaggeration: 20, Strength: 0.6}
+}
+
+// Shade returns the brightness of each point, 0..1, in row order.
+func (m *Map) Shade(opts ShadeOptions) []float64 {
+	az, alt := opts.Azimuth*math.Pi/180, opts.Altitude*math.Pi/180
+	sun := [3]float64{math.Sin(az) * math.Cos(alt), math.Cos(az) * math.Cos(alt), math.Sin(alt)}
+
+	normals := m.surfaceNormals(opts.Exaggeration)
+	shade := make([]float64, len(normals))
+	for n, nv := range normals {
+		if opts.Slope {
+			shade[n] = nv[2]
+		} else {
+			shade[n] = math.Max(0, nv[0]*sun[0]+nv[1]*sun[1]+nv[2]*sun[2])
+		}
+	}
+	return shade
+}
+
+// surfaceNormals returns the unit normal of the surface at each point, in row order,
+// with x east, y north and z up.
+// The slope at each point uses the eight points around it (Horn's method),
+// wrapping around the east and west edges of the map. The faults are
+// created on the flat map, so the points are treated as squares.
+func (m *Map) surfaceNormals(exaggeration float64) [][3]float64 {
+	height, width := m.Height(), m.Width()
+	heights := m.Heights()
+	z := func(x, y int) float64 {
+		if y < 0 {
+			y = 0
+		} else if y >= height {
+			y = height - 1
+		}
+		return float64(heights[y*width+(x+width)%width])
+	}
+	// convert heights to the same units as the distance between points
+	zScale := reliefScale * float64(width) * exaggeration
+
+	normals := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			a, b, c := z(x-1, y-1), z(x, y-1), z(x+1, y-1)
+			d, f := z(x-1, y), z(x+1, y)
+			g, h, i := z(x-1, y+1), z(x, y+1), z(x+1, y+1)
+			dzEast := ((c + 2*f + i) - (a + 2*d + g)) / 8 * zScale
+			dzNorth := ((a + 2*b + c) - (g + 2*h + i)) / 8 * zScale
+			length := math.Sqrt(dzEast*dzEast + dzNorth*dzNorth + 1)
+			normals[y*width+x] = [3]float64{-dzEast / length, -dzNorth / length, 1 / length}
+		}
+	}
+	return normals
+}
+
+// AsHillshade returns the shading as a greyscale image.
+func (m *Map) AsHillshade(opts ShadeOptions) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, m.Width(), m.Height()))
+	for n, s := range m.Shade(opts) {
+		img.Pix[n] = uint8(math.Round(s * 255))
+	}
+	return img
+}
+
+// AsShaded multiplies the shading over an image of the map, usually one
+// created by AsCarto or AsImage. Flat ground keeps its color and slopes facing
+// away from the sun are darkened by up to opts.Strength.
+func (m *Map) AsShaded(base image.Image, opts ShadeOptions) *image.RGBA {
+	shade := m.Shade(opts)
+	// scale so that flat ground is not darkened
+	flat := 1.0
+	if !opts.Slope {
+		flat = math.Max(math.Sin(opts.Altitude*math.Pi/180), 0.01)
+	}
+	strength := math.Max(0, math.Min(1, opts.Strength))
+
+	height, width := m.Height(), m.Width()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			k := 1 - strength*(1-math.Min(shade[y*width+x]/flat, 1))
+			r, g, b, a := base.At(base.Bounds().Min.X+x, base.Bounds().Min.Y+y).RGBA()
+			img.SetRGBA(x, y, color.RGBA{
+				R: uint8(float64(r>>8) * k),
+				G: uint8(float64(g>>8) * k),
+				B: uint8(float64(b>>8) * k),
+				A: uint8(a >> 8),
+			})
+		}
+	}
+	return img
+}
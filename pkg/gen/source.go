@@ -0,0 +1,47 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gen
+
+import "math/rand"
+
+// source wraps the standard source and counts the number of values drawn
+// from it. The seed and count are enough to put a new source back in the
+// same position, which lets us save a map and continue generating later.
+type source struct {
+	seed  int64
+	draws uint64
+	src   rand.Source
+}
+
+// newSource returns a source that has been advanced past the given number of draws.
+func newSource(seed int64, draws uint64) *source {
+	s := &source{seed: seed, src: rand.NewSource(seed)}
+	for s.draws < draws {
+		s.Int63()
+	}
+	return s
+}
+
+func (s *source) Int63() int64 {
+	s.draws++
+	return s.src.Int63()
+}
+
+func (s *source) Seed(seed int64) {
+	s.seed, s.draws = seed, 0
+	s.src.Seed(seed)
+}
@@ -0,0 +1,86 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gen
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// The textures in this file are for 3D renderers. They line up with the
+// images from AsCarto, so they can be used together on a globe or a mesh.
+
+// AsNormalMap returns a tangent-space normal map of the surface.
+// Red is east, green is north (the OpenGL convention) and blue is up,
+// each scaled from -1..1 to 0..255. The normals wrap around the east
+// and west edges, so there is no seam when the map is wrapped around a globe.
+func (m *Map) AsNormalMap(exaggeration float64) *image.RGBA {
+	height, width := m.Height(), m.Width()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for n, nv := range m.surfaceNormals(exaggeration) {
+		img.SetRGBA(n%width, n/width, color.RGBA{
+			R: uint8(math.Round((nv[0] + 1) * 127.5)),
+			G: uint8(math.Round((nv[1] + 1) * 127.5)),
+			B: uint8(math.Round((nv[2] + 1) * 127.5)),
+			A: 255,
+		})
+	}
+	return img
+}
+
+// AsWaterMask returns an image that is white where the map is water and
+// black where it is land, using the sea level for the percentage of water.
+func (m *Map) AsWaterMask(pctWater int) *image.Gray {
+	seaLevel := m.SeaLevel(pctWater)
+	img := image.NewGray(image.Rect(0, 0, m.Width(), m.Height()))
+	for n, val := range m.points {
+		if val <= seaLevel {
+			img.Pix[n] = 255
+		}
+	}
+	return img
+}
+
+// roughness of each type of surface, 0 (mirror) to 1 (matte)
+const (
+	waterRoughness = 0.1
+	iceRoughness   = 0.35
+	landRoughness  = 0.7
+)
+
+// AsRoughnessMap returns a roughness map for physically based renderers.
+// Water is smooth, ice is fairly smooth and land gets rougher as it gets steeper.
+func (m *Map) AsRoughnessMap(pctWater, pctIce int, exaggeration float64) *image.Gray {
+	seaLevel, iceLevel := m.SeaLevel(pctWater), m.IceLevel(pctIce)
+	normals := m.surfaceNormals(exaggeration)
+	img := image.NewGray(image.Rect(0, 0, m.Width(), m.Height()))
+	for n, val := range m.points {
+		var roughness float64
+		switch {
+		case val <= seaLevel:
+			roughness = waterRoughness
+		case val >= iceLevel:
+			roughness = iceRoughness
+		default:
+			// normals[n][2] is the cosine of the slope
+			roughness = landRoughness + (1-landRoughness)*(1-normals[n][2])
+		}
+		img.Pix[n] = uint8(math.Round(roughness * 255))
+	}
+	return img
+}
@@ -19,6 +19,7 @@ package generator
 
 import (
 	"bytes"
+	"context"
 	"image"
 	"image/color"
 	"image/png"
@@ -185,7 +186,16 @@ func (m *Map) Normalize() {
 }
 
 func (m *Map) RandomFractureCircle(n int) {
+	_ = m.RandomFractureCircleContext(context.Background(), n)
+}
+
+// RandomFractureCircleContext is like RandomFractureCircle but stops
+// between faults if the context is done, returning the context's error.
+func (m *Map) RandomFractureCircleContext(ctx context.Context, n int) error {
 	for n > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		// decide the amount that we're going to raise or lower
 		switch m.rnd.Intn(2) {
 		case 0:
@@ -195,6 +205,7 @@ func (m *Map) RandomFractureCircle(n int) {
 		}
 		n--
 	}
+	return nil
 }
 
 var (
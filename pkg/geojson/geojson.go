@@ -0,0 +1,231 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package geojson implements the parts of GeoJSON (RFC 7946) that we need
+// to export maps, and converts areas of the map into polygons.
+package geojson
+
+import (
+	"image"
+	"math"
+)
+
+type FeatureCollection struct {
+	Type     string     `json:"type"`
+	Features []*Feature `json:"features"`
+}
+
+func NewFeatureCollection() *FeatureCollection {
+	return &FeatureCollection{Type: "FeatureCollection", Features: []*Feature{}}
+}
+
+type Feature struct {
+	Type       string         `json:"type"`
+	ID         any            `json:"id,omitempty"`
+	Geometry   *Geometry      `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+func NewFeature(geometry *Geometry) *Feature {
+	return &Feature{Type: "Feature", Geometry: geometry, Properties: map[string]any{}}
+}
+
+// Geometry is a Point, MultiLineString or MultiPolygon, which is all we create.
+type Geometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+// Position is longitude, latitude.
+type Position [2]float64
+
+// Ring is a closed list of positions. The first and last positions are the same.
+type Ring []Position
+
+// Polygon is an exterior ring followed by any holes.
+type Polygon []Ring
+
+func NewPoint(p Position) *Geometry {
+	return &Geometry{Type: "Point", Coordinates: p}
+}
+
+func NewMultiLineString(lines [][]Position) *Geometry {
+	return &Geometry{Type: "MultiLineString", Coordinates: lines}
+}
+
+func NewMultiPolygon(polygons []Polygon) *Geometry {
+	return &Geometry{Type: "MultiPolygon", Coordinates: polygons}
+}
+
+// LonLat converts a position on a width x height equirectangular map to
+// longitude and latitude. The top-left corner of the map is 180°W 90°N.
+func LonLat(x, y float64, width, height int) Position {
+	return Position{
+		round(x*360/float64(width) - 180),
+		round(90 - y*180/float64(height)),
+	}
+}
+
+// round to about 10 meters, which is plenty for our maps.
+func round(f float64) float64 {
+	return math.Round(f*1e4) / 1e4
+}
+
+// Polygonize returns the polygons covering the points of a map where in returns true.
+// Only points inside bounds are considered.
+// The polygons follow the edges of the points, are split at the antimeridian
+// (the left and right edges of the map), and treat points that only touch at a
+// corner as separate. Exterior rings are counter-clockwise and holes are clockwise.
+func Polygonize(width, height int, bounds image.Rectangle, in func(x, y int) bool) []Polygon {
+	bounds = bounds.Intersect(image.Rect(0, 0, width, height))
+	inside := func(x, y int) bool {
+		return image.Pt(x, y).In(bounds) && in(x, y)
+	}
+
+	// label the pieces, which are 4-connected without wrapping.
+	bw := bounds.Dx()
+	piece := make([]int, bw*bounds.Dy())
+	index := func(x, y int) int {
+		return (y-bounds.Min.Y)*bw + (x - bounds.Min.X)
+	}
+	var polygons []Polygon
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if piece[index(x, y)] != 0 || !inside(x, y) {
+				continue
+			}
+			id := len(polygons) + 1
+			var pts []image.Point
+			stack := []image.Point{{X: x, Y: y}}
+			piece[index(x, y)] = id
+			for len(stack) != 0 {
+				p := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				pts = append(pts, p)
+				for _, d := range []image.Point{{X: 1}, {X: -1}, {Y: 1}, {Y: -1}} {
+					q := p.Add(d)
+					if inside(q.X, q.Y) && piece[index(q.X, q.Y)] == 0 {
+						piece[index(q.X, q.Y)] = id
+						stack = append(stack, q)
+					}
+				}
+			}
+			polygons = append(polygons, tracePiece(pts, func(x, y int) bool {
+				return image.Pt(x, y).In(bounds) && piece[index(x, y)] == id
+			}, width, height))
+		}
+	}
+	return polygons
+}
+
+// directions in image coordinates, where y increases going down
+var (
+	east  = image.Point{X: 1}
+	south = image.Point{Y: 1}
+	west  = image.Point{X: -1}
+	north = image.Point{Y: -1}
+)
+
+type boundaryEdge struct {
+	from image.Point
+	dir  image.Point
+}
+
+// tracePiece returns the polygon outlining a single 4-connected piece.
+func tracePiece(pts []image.Point, in func(x, y int) bool, width, height int) Polygon {
+	// collect the edges between the piece and its neighbors, walking
+	// clockwise around each point so the piece is on the right.
+	var edges []boundaryEdge
+	for _, p := range pts {
+		x, y := p.X, p.Y
+		if !in(x, y-1) {
+			edges = append(edges, boundaryEdge{from: image.Pt(x, y), dir: east})
+		}
+		if !in(x+1, y) {
+			edges = append(edges, boundaryEdge{from: image.Pt(x+1, y), dir: south})
+		}
+		if !in(x, y+1) {
+			edges = append(edges, boundaryEdge{from: image.Pt(x+1, y+1), dir: west})
+		}
+		if !in(x-1, y) {
+			edges = append(edges, boundaryEdge{from: image.Pt(x, y+1), dir: north})
+		}
+	}
+	byStart := make(map[image.Point][]int, len(edges))
+	for n, e := range edges {
+		byStart[e.from] = append(byStart[e.from], n)
+	}
+
+	used := make([]bool, len(edges))
+	var exterior Ring
+	var holes []Ring
+	for start := range edges {
+		if used[start] {
+			continue
+		}
+		// follow the edges until we are back at the start.
+		// where two edges leave a corner, turn right to keep the piece on the right.
+		var corners []image.Point
+		for n := start; !used[n]; {
+			used[n] = true
+			e := edges[n]
+			corners = append(corners, e.from)
+			to := e.from.Add(e.dir)
+			next := -1
+			for _, dir := range []image.Point{{X: -e.dir.Y, Y: e.dir.X}, e.dir, {X: e.dir.Y, Y: -e.dir.X}} {
+				for _, m := range byStart[to] {
+					if !used[m] && edges[m].dir == dir {
+						next = m
+						break
+					}
+				}
+				if next != -1 {
+					break
+				}
+			}
+			if next == -1 {
+				break
+			}
+			n = next
+		}
+
+		// drop the corners in the middle of straight lines. the corners are
+		// added in reverse since GeoJSON wants the exterior counter-clockwise.
+		var ring Ring
+		area := 0
+		for i := len(corners) - 1; i >= 0; i-- {
+			c := corners[i]
+			prev, next := corners[(i+len(corners)-1)%len(corners)], corners[(i+1)%len(corners)]
+			area += c.X*next.Y - next.X*c.Y
+			if (c.X-prev.X)*(next.Y-c.Y) == (c.Y-prev.Y)*(next.X-c.X) {
+				continue
+			}
+			ring = append(ring, LonLat(float64(c.X), float64(c.Y), width, height))
+		}
+		if len(ring) < 3 {
+			continue
+		}
+		ring = append(ring, ring[0])
+
+		// the piece is on the right of the edges, so the exterior is clockwise
+		if area > 0 {
+			exterior = ring
+		} else {
+			holes = append(holes, ring)
+		}
+	}
+	return append(Polygon{exterior}, holes...)
+}
@@ -0,0 +1,144 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package geotiff implements a minimal GeoTIFF encoder.
+//
+// It writes a single band, uncompressed, float32 raster that covers the
+// entire globe using the equirectangular (EPSG:4326) layout of our maps.
+// The top-left pixel is at 180°W 90°N.
+package geotiff
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// TIFF field types
+const (
+	typeShort  = 3
+	typeLong   = 4
+	typeDouble = 12
+)
+
+// TIFF and GeoTIFF tags
+const (
+	tagImageWidth                = 256
+	tagImageLength               = 257
+	tagBitsPerSample             = 258
+	tagCompression               = 259
+	tagPhotometricInterpretation = 262
+	tagStripOffsets              = 273
+	tagSamplesPerPixel           = 277
+	tagRowsPerStrip              = 278
+	tagStripByteCounts           = 279
+	tagPlanarConfiguration       = 284
+	tagSampleFormat              = 339
+	tagModelPixelScale           = 33550
+	tagModelTiepoint             = 33922
+	tagGeoKeyDirectory           = 34735
+)
+
+// GeoTIFF keys
+const (
+	keyGTModelType      = 1024
+	keyGTRasterType     = 1025
+	keyGeographicType   = 2048
+	keyGeogAngularUnits = 2054
+
+	modelTypeGeographic = 2
+	rasterPixelIsArea   = 1
+	gcsWGS84            = 4326
+	angularDegree       = 9102
+)
+
+type entry struct {
+	tag, typ uint16
+	count    uint32
+	value    uint32 // value, or offset to the values if they don't fit
+}
+
+// Encode writes the pixels as a GeoTIFF.
+// The pixels must be in row order, starting with the northern-most row.
+func Encode(w io.Writer, width, height int, pixels []float32) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("geotiff: invalid dimensions %d x %d", width, height)
+	} else if len(pixels) != width*height {
+		return fmt.Errorf("geotiff: want %d pixels, got %d", width*height, len(pixels))
+	} else if uint64(len(pixels))*4 > 1<<31 {
+		return fmt.Errorf("geotiff: image is too large")
+	}
+
+	// georeferencing
+	pixelScale := []float64{360 / float64(width), 180 / float64(height), 0}
+	tiepoint := []float64{0, 0, 0, -180, 90, 0}
+	geoKeys := []uint16{
+		1, 1, 0, 4, // version, revision, minor revision, number of keys
+		keyGTModelType, 0, 1, modelTypeGeographic,
+		keyGTRasterType, 0, 1, rasterPixelIsArea,
+		keyGeographicType, 0, 1, gcsWGS84,
+		keyGeogAngularUnits, 0, 1, angularDegree,
+	}
+
+	// the file is the header, the pixels, the georeferencing values, and then the IFD.
+	const headerSize = 8
+	stripSize := uint32(len(pixels) * 4)
+	pixelScaleOffset := headerSize + stripSize
+	tiepointOffset := pixelScaleOffset + uint32(len(pixelScale)*8)
+	geoKeysOffset := tiepointOffset + uint32(len(tiepoint)*8)
+	ifdOffset := geoKeysOffset + uint32(len(geoKeys)*2)
+
+	// entries must be sorted by tag
+	entries := []entry{
+		{tagImageWidth, typeLong, 1, uint32(width)},
+		{tagImageLength, typeLong, 1, uint32(height)},
+		// a single short fits in the low bytes of the value
+		{tagBitsPerSample, typeShort, 1, 32},
+		{tagCompression, typeShort, 1, 1},               // none
+		{tagPhotometricInterpretation, typeShort, 1, 1}, // black is zero
+		{tagStripOffsets, typeLong, 1, headerSize},
+		{tagSamplesPerPixel, typeShort, 1, 1},
+		{tagRowsPerStrip, typeLong, 1, uint32(height)},
+		{tagStripByteCounts, typeLong, 1, stripSize},
+		{tagPlanarConfiguration, typeShort, 1, 1}, // chunky
+		{tagSampleFormat, typeShort, 1, 3},        // IEEE floating point
+		{tagModelPixelScale, typeDouble, uint32(len(pixelScale)), pixelScaleOffset},
+		{tagModelTiepoint, typeDouble, uint32(len(tiepoint)), tiepointOffset},
+		{tagGeoKeyDirectory, typeShort, uint32(len(geoKeys)), geoKeysOffset},
+	}
+
+	bw := bufio.NewWriter(w)
+	le := binary.LittleEndian
+	for _, v := range []any{
+		[]byte("II"), uint16(42), ifdOffset,
+		pixels,
+		pixelScale, tiepoint, geoKeys,
+		uint16(len(entries)),
+	} {
+		if err := binary.Write(bw, le, v); err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(bw, le, entries); err != nil {
+		return err
+	}
+	// no more IFDs
+	if err := binary.Write(bw, le, uint32(0)); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,228 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mesh
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+)
+
+// glTF constants
+const (
+	gltfFloat        = 5126
+	gltfUnsignedInt  = 5125
+	gltfArrayBuffer  = 34962
+	gltfElementArray = 34963
+	gltfLinear       = 9729
+	gltfRepeat       = 10497
+	gltfClampToEdge  = 33071
+)
+
+// the subset of the glTF 2.0 schema that we use
+type gltfDoc struct {
+	Asset       gltfAsset        `json:"asset"`
+	Scene       int              `json:"scene"`
+	Scenes      []gltfScene      `json:"scenes"`
+	Nodes       []gltfNode       `json:"nodes"`
+	Meshes      []gltfMesh       `json:"meshes"`
+	Materials   []gltfMaterial   `json:"materials,omitempty"`
+	Textures    []gltfTexture    `json:"textures,omitempty"`
+	Images      []gltfImage      `json:"images,omitempty"`
+	Samplers    []gltfSampler    `json:"samplers,omitempty"`
+	Accessors   []gltfAccessor   `json:"accessors"`
+	BufferViews []gltfBufferView `json:"bufferViews"`
+	Buffers     []gltfBuffer     `json:"buffers"`
+}
+
+type gltfAsset struct {
+	Version   string `json:"version"`
+	Generator string `json:"generator"`
+}
+
+type gltfScene struct {
+	Nodes []int `json:"nodes"`
+}
+
+type gltfNode struct {
+	Mesh int `json:"mesh"`
+}
+
+type gltfMesh struct {
+	Primitives []gltfPrimitive `json:"primitives"`
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    int            `json:"indices"`
+	Material   *int           `json:"material,omitempty"`
+}
+
+type gltfMaterial struct {
+	PBR gltfPBR `json:"pbrMetallicRoughness"`
+}
+
+type gltfPBR struct {
+	BaseColorTexture *gltfTextureInfo `json:"baseColorTexture,omitempty"`
+	MetallicFactor   float64          `json:"metallicFactor"`
+	RoughnessFactor  float64          `json:"roughnessFactor"`
+}
+
+type gltfTextureInfo struct {
+	Index int `json:"index"`
+}
+
+type gltfTexture struct {
+	Sampler int `json:"sampler"`
+	Source  int `json:"source"`
+}
+
+type gltfImage struct {
+	URI string `json:"uri"`
+}
+
+type gltfSampler struct {
+	MagFilter int `json:"magFilter"`
+	MinFilter int `json:"minFilter"`
+	WrapS     int `json:"wrapS"`
+	WrapT     int `json:"wrapT"`
+}
+
+type gltfAccessor struct {
+	BufferView    int       `json:"bufferView"`
+	ComponentType int       `json:"componentType"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float64 `json:"min,omitempty"`
+	Max           []float64 `json:"max,omitempty"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	Target     int `json:"target"`
+}
+
+type gltfBuffer struct {
+	ByteLength int    `json:"byteLength"`
+	URI        string `json:"uri"`
+}
+
+// WriteGLTF writes the mesh as a self-contained glTF 2.0 file.
+// The buffers are embedded as data URIs. If texture is not empty, it must
+// be an encoded PNG and is applied as the base color of the mesh.
+func (m *Mesh) WriteGLTF(w io.Writer, texture []byte) error {
+	doc := gltfDoc{
+		Asset:  gltfAsset{Version: "2.0", Generator: "worldgen"},
+		Scenes: []gltfScene{{Nodes: []int{0}}},
+		Nodes:  []gltfNode{{Mesh: 0}},
+	}
+	prim := gltfPrimitive{Attributes: map[string]int{}}
+
+	// every section of the buffer is padded to a multiple of four bytes
+	bb := &bytes.Buffer{}
+	addView := func(data any, target int) int {
+		offset := bb.Len()
+		_ = binary.Write(bb, binary.LittleEndian, data)
+		doc.BufferViews = append(doc.BufferViews, gltfBufferView{ByteOffset: offset, ByteLength: bb.Len() - offset, Target: target})
+		for bb.Len()%4 != 0 {
+			bb.WriteByte(0)
+		}
+		return len(doc.BufferViews) - 1
+	}
+	addAccessor := func(a gltfAccessor) int {
+		doc.Accessors = append(doc.Accessors, a)
+		return len(doc.Accessors) - 1
+	}
+
+	positions := make([][3]float32, len(m.Vertices))
+	minPos := []float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	maxPos := []float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for n, v := range m.Vertices {
+		for i := range v {
+			positions[n][i] = float32(v[i])
+			minPos[i] = math.Min(minPos[i], float64(positions[n][i]))
+			maxPos[i] = math.Max(maxPos[i], float64(positions[n][i]))
+		}
+	}
+	prim.Attributes["POSITION"] = addAccessor(gltfAccessor{
+		BufferView:    addView(positions, gltfArrayBuffer),
+		ComponentType: gltfFloat,
+		Count:         len(positions),
+		Type:          "VEC3",
+		Min:           minPos,
+		Max:           maxPos,
+	})
+
+	normals := make([][3]float32, len(m.Vertices))
+	for n, v := range m.Normals() {
+		normals[n] = [3]float32{float32(v[0]), float32(v[1]), float32(v[2])}
+	}
+	prim.Attributes["NORMAL"] = addAccessor(gltfAccessor{
+		BufferView:    addView(normals, gltfArrayBuffer),
+		ComponentType: gltfFloat,
+		Count:         len(normals),
+		Type:          "VEC3",
+	})
+
+	if len(m.UVs) == len(m.Vertices) {
+		uvs := make([][2]float32, len(m.UVs))
+		for n, uv := range m.UVs {
+			uvs[n] = [2]float32{float32(uv[0]), float32(uv[1])}
+		}
+		prim.Attributes["TEXCOORD_0"] = addAccessor(gltfAccessor{
+			BufferView:    addView(uvs, gltfArrayBuffer),
+			ComponentType: gltfFloat,
+			Count:         len(uvs),
+			Type:          "VEC2",
+		})
+	}
+
+	indices := make([]uint32, 0, 3*len(m.Faces))
+	for _, f := range m.Faces {
+		indices = append(indices, uint32(f[0]), uint32(f[1]), uint32(f[2]))
+	}
+	prim.Indices = addAccessor(gltfAccessor{
+		BufferView:    addView(indices, gltfElementArray),
+		ComponentType: gltfUnsignedInt,
+		Count:         len(indices),
+		Type:          "SCALAR",
+	})
+
+	material := gltfMaterial{PBR: gltfPBR{MetallicFactor: 0, RoughnessFactor: 1}}
+	if len(texture) != 0 {
+		material.PBR.BaseColorTexture = &gltfTextureInfo{Index: 0}
+		doc.Textures = []gltfTexture{{Sampler: 0, Source: 0}}
+		doc.Images = []gltfImage{{URI: "data:image/png;base64," + base64.StdEncoding.EncodeToString(texture)}}
+		// the texture wraps around the globe but not over the poles
+		doc.Samplers = []gltfSampler{{MagFilter: gltfLinear, MinFilter: gltfLinear, WrapS: gltfRepeat, WrapT: gltfClampToEdge}}
+	}
+	doc.Materials = []gltfMaterial{material}
+	prim.Material = new(int)
+	doc.Meshes = []gltfMesh{{Primitives: []gltfPrimitive{prim}}}
+
+	doc.Buffers = []gltfBuffer{{
+		ByteLength: bb.Len(),
+		URI:        "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(bb.Bytes()),
+	}}
+
+	return json.NewEncoder(w).Encode(doc)
+}
@@ -0,0 +1,253 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package mesh turns heightmaps into triangle meshes.
+package mesh
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+type Vec3 [3]float64
+
+func (a Vec3) sub(b Vec3) Vec3 {
+	return Vec3{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func (a Vec3) cross(b Vec3) Vec3 {
+	return Vec3{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func (a Vec3) normalize() Vec3 {
+	length := math.Sqrt(a[0]*a[0] + a[1]*a[1] + a[2]*a[2])
+	if length == 0 {
+		return a
+	}
+	return Vec3{a[0] / length, a[1] / length, a[2] / length}
+}
+
+// Mesh is an indexed triangle mesh.
+// Faces are wound counter-clockwise when seen from the outside.
+type Mesh struct {
+	Vertices []Vec3
+	UVs      [][2]float64 // texture coordinates, may be empty
+	Faces    [][3]int
+}
+
+// faceNormal returns the unit normal of face n.
+func (m *Mesh) faceNormal(n int) Vec3 {
+	a, b, c := m.Vertices[m.Faces[n][0]], m.Vertices[m.Faces[n][1]], m.Vertices[m.Faces[n][2]]
+	return b.sub(a).cross(c.sub(a)).normalize()
+}
+
+// Normals returns smooth vertex normals, the average of the normals of
+// the faces that share the vertex (weighted by area).
+func (m *Mesh) Normals() []Vec3 {
+	normals := make([]Vec3, len(m.Vertices))
+	for _, f := range m.Faces {
+		a, b, c := m.Vertices[f[0]], m.Vertices[f[1]], m.Vertices[f[2]]
+		fn := b.sub(a).cross(c.sub(a))
+		for _, v := range f {
+			normals[v] = Vec3{normals[v][0] + fn[0], normals[v][1] + fn[1], normals[v][2] + fn[2]}
+		}
+	}
+	for n := range normals {
+		normals[n] = normals[n].normalize()
+	}
+	return normals
+}
+
+// ReliefOptions controls the mesh created by Relief.
+type ReliefOptions struct {
+	Region image.Rectangle // area of the map to use, the whole map if empty
+	Step   int             // distance between samples, in map points
+	Scale  float64         // height of the highest point, in map points
+	Base   float64         // thickness of the base under the lowest point
+}
+
+// Relief returns a closed mesh of a region of the heightmap, suitable for 3D printing.
+// The heights are in row order and should be in the range 0..1.
+// X runs east, Y runs north, and Z is up. The bottom of the base is at Z = 0.
+func Relief(heights []float32, width, height int, opts ReliefOptions) (*Mesh, error) {
+	if len(heights) != width*height {
+		return nil, fmt.Errorf("mesh: want %d heights, got %d", width*height, len(heights))
+	}
+	region := opts.Region
+	if region.Empty() {
+		region = image.Rect(0, 0, width, height)
+	}
+	region = region.Intersect(image.Rect(0, 0, width, height))
+	if region.Empty() {
+		return nil, fmt.Errorf("mesh: region is outside the map")
+	}
+	step := opts.Step
+	if step < 1 {
+		step = 1
+	}
+	// sample points, always including the right and bottom edges of the region
+	var xs, ys []int
+	for x := region.Min.X; x < region.Max.X; x += step {
+		xs = append(xs, x)
+	}
+	if xs[len(xs)-1] != region.Max.X-1 {
+		xs = append(xs, region.Max.X-1)
+	}
+	for y := region.Min.Y; y < region.Max.Y; y += step {
+		ys = append(ys, y)
+	}
+	if ys[len(ys)-1] != region.Max.Y-1 {
+		ys = append(ys, region.Max.Y-1)
+	}
+	cols, rows := len(xs), len(ys)
+	if cols < 2 || rows < 2 {
+		return nil, fmt.Errorf("mesh: region %v is too small", region)
+	}
+
+	m := &Mesh{}
+	top := func(row, col int) int {
+		return row*cols + col
+	}
+	for _, y := range ys {
+		for _, x := range xs {
+			z := opts.Base + float64(heights[y*width+x])*opts.Scale
+			m.Vertices = append(m.Vertices, Vec3{float64(x - region.Min.X), float64(region.Max.Y - 1 - y), z})
+			m.UVs = append(m.UVs, [2]float64{float64(x) / float64(width-1), float64(y) / float64(height-1)})
+		}
+	}
+	for row := 0; row+1 < rows; row++ {
+		for col := 0; col+1 < cols; col++ {
+			v00, v01, v10, v11 := top(row, col), top(row, col+1), top(row+1, col), top(row+1, col+1)
+			m.Faces = append(m.Faces, [3]int{v00, v10, v11}, [3]int{v00, v11, v01})
+		}
+	}
+
+	// walk the edge of the top surface clockwise (seen from above),
+	// starting at the north-west corner.
+	var edge []int
+	for col := 0; col < cols-1; col++ {
+		edge = append(edge, top(0, col))
+	}
+	for row := 0; row < rows-1; row++ {
+		edge = append(edge, top(row, cols-1))
+	}
+	for col := cols - 1; col > 0; col-- {
+		edge = append(edge, top(rows-1, col))
+	}
+	for row := rows - 1; row > 0; row-- {
+		edge = append(edge, top(row, 0))
+	}
+
+	// drop the edge down to the bottom to make the walls
+	bottom := len(m.Vertices)
+	for _, v := range edge {
+		m.Vertices = append(m.Vertices, Vec3{m.Vertices[v][0], m.Vertices[v][1], 0})
+		m.UVs = append(m.UVs, m.UVs[v])
+	}
+	for i := range edge {
+		j := (i + 1) % len(edge)
+		a, b, aBot, bBot := edge[i], edge[j], bottom+i, bottom+j
+		m.Faces = append(m.Faces, [3]int{a, b, bBot}, [3]int{a, bBot, aBot})
+	}
+
+	// close the bottom with a fan around its center
+	center := len(m.Vertices)
+	m.Vertices = append(m.Vertices, Vec3{float64(region.Dx()-1) / 2, float64(region.Dy()-1) / 2, 0})
+	m.UVs = append(m.UVs, [2]float64{0.5, 0.5})
+	for i := range edge {
+		m.Faces = append(m.Faces, [3]int{center, bottom + i, bottom + (i+1)%len(edge)})
+	}
+
+	return m, nil
+}
+
+// SphereOptions controls the mesh created by Sphere.
+type SphereOptions struct {
+	Step         int     // distance between samples, in map points
+	Radius       float64 // radius of the sphere at height zero
+	Displacement float64 // amount added to the radius at height one
+}
+
+// Sphere returns a globe made by wrapping the equirectangular heightmap
+// around a sphere and displacing the surface by the heights.
+// The heights are in row order and should be in the range 0..1.
+// Y is up (the north pole), following the glTF convention.
+// The texture coordinates map the entire map image onto the globe.
+func Sphere(heights []float32, width, height int, opts SphereOptions) (*Mesh, error) {
+	if len(heights) != width*height {
+		return nil, fmt.Errorf("mesh: want %d heights, got %d", width*height, len(heights))
+	}
+	step := opts.Step
+	if step < 1 {
+		step = 1
+	}
+	cols, rows := (width+step-1)/step, (height+step-1)/step
+	if cols < 3 || rows < 2 {
+		return nil, fmt.Errorf("mesh: map is too small for step %d", step)
+	}
+
+	m := &Mesh{}
+	// there is an extra column so that the seam can have its own texture coordinates,
+	// and an extra row so that both poles are included.
+	for row := 0; row <= rows; row++ {
+		v := float64(row) / float64(rows)
+		lat := math.Pi/2 - v*math.Pi
+		y := row * height / rows
+		if y >= height {
+			y = height - 1
+		}
+		// all the points at a pole must meet, so use the average height there
+		var poleHeight float64
+		if row == 0 || row == rows {
+			for _, val := range heights[y*width : (y+1)*width] {
+				poleHeight += float64(val)
+			}
+			poleHeight /= float64(width)
+		}
+		for col := 0; col <= cols; col++ {
+			u := float64(col) / float64(cols)
+			lon := u*2*math.Pi - math.Pi
+			x := (col * width / cols) % width
+			h := float64(heights[y*width+x])
+			if row == 0 || row == rows {
+				h = poleHeight
+			}
+			r := opts.Radius + h*opts.Displacement
+			m.Vertices = append(m.Vertices, Vec3{
+				r * math.Cos(lat) * math.Cos(lon),
+				r * math.Sin(lat),
+				-r * math.Cos(lat) * math.Sin(lon),
+			})
+			m.UVs = append(m.UVs, [2]float64{u, v})
+		}
+	}
+	stride := cols + 1
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			v00, v01 := row*stride+col, row*stride+col+1
+			v10, v11 := v00+stride, v01+stride
+			// skip the triangles that collapse at the poles
+			if row != rows-1 {
+				m.Faces = append(m.Faces, [3]int{v00, v10, v11})
+			}
+			if row != 0 {
+				m.Faces = append(m.Faces, [3]int{v00, v11, v01})
+			}
+		}
+	}
+	return m, nil
+}
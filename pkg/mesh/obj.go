@@ -0,0 +1,48 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mesh
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// WriteOBJ writes the mesh as a Wavefront OBJ file.
+func (m *Mesh) WriteOBJ(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	_, _ = fmt.Fprintf(bw, "# worldgen mesh: %d vertices, %d faces\n", len(m.Vertices), len(m.Faces))
+	for _, v := range m.Vertices {
+		_, _ = fmt.Fprintf(bw, "v %g %g %g\n", v[0], v[1], v[2])
+	}
+	hasUVs := len(m.UVs) == len(m.Vertices)
+	if hasUVs {
+		// OBJ puts the origin of the texture at the bottom left
+		for _, uv := range m.UVs {
+			_, _ = fmt.Fprintf(bw, "vt %g %g\n", uv[0], 1-uv[1])
+		}
+	}
+	// indices are 1-based
+	for _, f := range m.Faces {
+		if hasUVs {
+			_, _ = fmt.Fprintf(bw, "f %d/%d %d/%d %d/%d\n", f[0]+1, f[0]+1, f[1]+1, f[1]+1, f[2]+1, f[2]+1)
+		} else {
+			_, _ = fmt.Fprintf(bw, "f %d %d %d\n", f[0]+1, f[1]+1, f[2]+1)
+		}
+	}
+	return bw.Flush()
+}
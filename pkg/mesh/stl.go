@@ -0,0 +1,54 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mesh
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+type stlTriangle struct {
+	Normal    [3]float32
+	Vertices  [3][3]float32
+	Attribute uint16
+}
+
+// WriteSTL writes the mesh as a binary STL file.
+func (m *Mesh) WriteSTL(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var header [80]byte
+	copy(header[:], "worldgen mesh")
+	if err := binary.Write(bw, binary.LittleEndian, header); err != nil {
+		return err
+	} else if err = binary.Write(bw, binary.LittleEndian, uint32(len(m.Faces))); err != nil {
+		return err
+	}
+	for n, f := range m.Faces {
+		var t stlTriangle
+		fn := m.faceNormal(n)
+		t.Normal = [3]float32{float32(fn[0]), float32(fn[1]), float32(fn[2])}
+		for i, v := range f {
+			vx := m.Vertices[v]
+			t.Vertices[i] = [3]float32{float32(vx[0]), float32(vx[1]), float32(vx[2])}
+		}
+		if err := binary.Write(bw, binary.LittleEndian, &t); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
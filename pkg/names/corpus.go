@@ -0,0 +1,41 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package names
+
+// defaultCorpus is a mix of real and invented place names with
+// an old-world sound to them.
+var defaultCorpus = []string{
+	"abalone", "adria", "aegea", "alara", "albion", "aldoran", "almeria", "amara",
+	"andora", "anselm", "aquila", "arcadia", "ardenne", "arkadia", "armorica", "arvala",
+	"astoria", "avalon", "azoria", "balmora", "barovia", "bastia", "belaria", "beringa",
+	"borealis", "brabant", "brenna", "brittany", "calabria", "caldera", "calydon", "camorra",
+	"carinthia", "carthia", "castilla", "catalan", "cimmeria", "corinth", "cormyra", "corsica",
+	"cyrene", "dalmatia", "darvon", "delvera", "doria", "dravenna", "elara", "eldoria",
+	"elysia", "emeria", "eridan", "estoril", "etruria", "faloria", "fenmark", "galatia",
+	"galicia", "gallia", "gascony", "gondara", "halcyon", "helvetia", "hesperia", "iberia",
+	"idris", "ilyria", "istria", "ithaca", "jutland", "kalmar", "karelia", "kastora",
+	"lanark", "latvara", "lemuria", "liguria", "lombard", "lorraine", "lucania", "lycia",
+	"lydia", "macedon", "magnesia", "marakesh", "maremma", "mercia", "meridia", "mirova",
+	"moravia", "morvena", "myrtana", "narbon", "navarre", "nemora", "northumbria", "numidia",
+	"occitan", "olmara", "orvieto", "ossaria", "palmyra", "pannonia", "parthia", "pelagia",
+	"perinthia", "phrygia", "pomerania", "provence", "rhaetia", "rivenna", "rodhia", "sabina",
+	"salonica", "samaria", "sardinia", "savona", "saxony", "scandia", "serica", "silesia",
+	"sirmia", "solvara", "sorrento", "styria", "suevia", "taranto", "tarsus", "tauris",
+	"thessaly", "thrace", "thule", "toscana", "tranvia", "tyrrhen", "umbria", "valdora",
+	"valencia", "valmora", "varena", "venetia", "verona", "vestra", "vindalia", "volhynia",
+	"wessex", "zamora", "zarvana", "zeeland",
+}
@@ -0,0 +1,66 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package names
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// patterns are the ways a name is turned into the name of a feature.
+// Features that aren't listed get the bare name.
+var patterns = map[string][]string{
+	"ocean":     {"%s Ocean", "Sea of %s", "%s Sea"},
+	"lake":      {"Lake %s", "%s Lake"},
+	"island":    {"%s Island", "Isle of %s", "%s"},
+	"ice":       {"%s Ice Cap", "%s Glacier"},
+	"peak":      {"Mount %s", "%s Peak"},
+	"range":     {"%s Mountains", "%s Range"},
+	"continent": {"%s"},
+}
+
+// Namer names the features of one world. The names depend only on the
+// seed and the order in which features are named, so a world named in
+// the same order always gets the same names. No name is used twice.
+type Namer struct {
+	g    *Generator
+	rnd  *rand.Rand
+	used map[string]bool
+}
+
+// Namer returns a namer seeded from the world seed.
+func (g *Generator) Namer(seed int64) *Namer {
+	return &Namer{g: g, rnd: rand.New(rand.NewSource(seed)), used: make(map[string]bool)}
+}
+
+// Name returns a new name for a feature of the given kind,
+// such as "ocean", "lake", "island", "peak" or "range".
+func (n *Namer) Name(kind string) string {
+	forms, ok := patterns[kind]
+	if !ok {
+		forms = []string{"%s"}
+	}
+	var name string
+	for try := 0; try < 100; try++ {
+		name = n.g.Name(n.rnd)
+		if !n.used[name] {
+			break
+		}
+	}
+	n.used[name] = true
+	return fmt.Sprintf(forms[n.rnd.Intn(len(forms))], name)
+}
@@ -0,0 +1,165 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package names generates place names from Markov chains over the
+// letters of a corpus of example names.
+package names
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"unicode"
+)
+
+const (
+	start = '^' // pads the beginning of each word
+	stop  = '$' // ends each word
+)
+
+// Generator makes names that look like the names in its corpus.
+type Generator struct {
+	order  int
+	next   map[string][]rune // letters seen after each run of order letters
+	known  map[string]bool   // names in the corpus, which aren't generated
+	minLen int
+	maxLen int
+}
+
+// New returns a generator for the corpus. The order is the number of letters
+// used to pick the next one; higher orders make names closer to the corpus.
+// Names are between minLen and maxLen letters long.
+func New(corpus []string, order, minLen, maxLen int) (*Generator, error) {
+	if order < 1 {
+		return nil, fmt.Errorf("order must be at least 1")
+	} else if minLen < 1 || maxLen < minLen {
+		return nil, fmt.Errorf("invalid name length %d..%d", minLen, maxLen)
+	}
+	g := &Generator{
+		order:  order,
+		next:   make(map[string][]rune),
+		known:  make(map[string]bool),
+		minLen: minLen,
+		maxLen: maxLen,
+	}
+	for _, word := range corpus {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word == "" {
+			continue
+		}
+		g.known[word] = true
+		letters := append([]rune(strings.Repeat(string(start), order)+word), stop)
+		for i := order; i < len(letters); i++ {
+			key := string(letters[i-order : i])
+			g.next[key] = append(g.next[key], letters[i])
+		}
+	}
+	if len(g.known) == 0 {
+		return nil, errors.New("empty corpus")
+	}
+	return g, nil
+}
+
+// Default returns a generator for the built-in corpus.
+func Default() *Generator {
+	g, err := New(defaultCorpus, 3, 4, 10)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// Name returns a capitalized name that isn't in the corpus.
+// If it can't find one after a reasonable number of tries, it
+// returns a name from the corpus rather than failing.
+func (g *Generator) Name(rnd *rand.Rand) string {
+	var name string
+	for try := 0; try < 100; try++ {
+		if name = g.word(rnd); name == "" || g.known[name] {
+			continue
+		} else if n := len([]rune(name)); g.minLen <= n && n <= g.maxLen {
+			return capitalize(name)
+		}
+	}
+	if name == "" {
+		// the chain always ends, so this only happens with a corpus of one-letter words
+		name = "nameless"
+	}
+	return capitalize(name)
+}
+
+// word walks the chain from the start of a word to its end.
+func (g *Generator) word(rnd *rand.Rand) string {
+	key := []rune(strings.Repeat(string(start), g.order))
+	var letters []rune
+	for len(letters) <= 2*g.maxLen {
+		choices := g.next[string(key)]
+		ch := choices[rnd.Intn(len(choices))]
+		if ch == stop {
+			break
+		}
+		letters = append(letters, ch)
+		key = append(key[1:], ch)
+	}
+	return string(letters)
+}
+
+// capitalize upper-cases the first letter of each word in the name.
+func capitalize(name string) string {
+	words := strings.Fields(name)
+	for i, word := range words {
+		r := []rune(word)
+		r[0] = unicode.ToUpper(r[0])
+		words[i] = string(r)
+	}
+	return strings.Join(words, " ")
+}
+
+// ReadCorpus reads names, one per line. Blank lines and lines
+// starting with a '#' are ignored.
+func ReadCorpus(r io.Reader) ([]string, error) {
+	var corpus []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		corpus = append(corpus, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return corpus, nil
+}
+
+// LoadCorpus reads a corpus file.
+func LoadCorpus(path string) ([]string, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	corpus, err := ReadCorpus(fp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return corpus, nil
+}
@@ -17,6 +17,7 @@
 package sliced
 
 import (
+	"context"
 	"github.com/mdhender/worldgen/pkg/smite"
 	"image"
 	"log"
@@ -25,8 +26,17 @@ import (
 )
 
 func Generate(height, width, iterations int) (*image.RGBA, error) {
+	return GenerateContext(context.Background(), height, width, iterations)
+}
+
+// GenerateContext is like Generate but stops between iterations
+// if the context is done, returning the context's error.
+func GenerateContext(ctx context.Context, height, width, iterations int) (*image.RGBA, error) {
 	world := twoDimensionalArray(height, width)
 	for iterations > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		// decide the amount that we're going to raise or lower
 		switch rand.Intn(2) {
 		case 0:
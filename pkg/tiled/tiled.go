@@ -17,6 +17,7 @@
 package tiled
 
 import (
+	"context"
 	"image"
 	"log"
 	"math"
@@ -25,8 +26,17 @@ import (
 )
 
 func Generate(height, width, iterations int, rnd *rand.Rand) (*image.RGBA, error) {
+	return GenerateContext(context.Background(), height, width, iterations, rnd)
+}
+
+// GenerateContext is like Generate but stops between iterations
+// if the context is done, returning the context's error.
+func GenerateContext(ctx context.Context, height, width, iterations int, rnd *rand.Rand) (*image.RGBA, error) {
 	world := twoDimensionalArray(height, width)
 	for iterations > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		// decide the amount that we're going to raise or lower
 		switch rnd.Intn(2) {
 		case 0:
@@ -0,0 +1,156 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package tiles cuts equirectangular maps into square tiles for slippy map viewers.
+//
+// The tiles use the same scheme as EPSG:4326 in Leaflet and WorldCRS84Quad
+// in WMTS: zoom level 0 is two tiles side by side, covering the western and
+// eastern hemispheres, and each zoom level doubles the tiles in both directions.
+// Tile 0, 0 is at the top-left (180°W, 90°N).
+package tiles
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Size is the width and height of a tile in pixels.
+const Size = 256
+
+// Grid returns the number of tiles across and down at the zoom level.
+func Grid(z int) (cols, rows int) {
+	return 2 << z, 1 << z
+}
+
+// MaxZoom returns the deepest zoom level worth rendering for a map that is
+// width pixels wide. Past it, a map pixel covers more than 8 tile pixels.
+func MaxZoom(width int) int {
+	z := 0
+	for cols, _ := Grid(z + 1); cols*Size <= 8*width; cols, _ = Grid(z + 1) {
+		z++
+	}
+	return z
+}
+
+// Validate returns an error if the tile is not on the grid for the zoom level.
+func Validate(z, x, y, maxZoom int) error {
+	if z < 0 || z > maxZoom {
+		return fmt.Errorf("zoom must be between 0 and %d", maxZoom)
+	}
+	cols, rows := Grid(z)
+	if x < 0 || x >= cols || y < 0 || y >= rows {
+		return fmt.Errorf("tile %d/%d/%d is off the map", z, x, y)
+	}
+	return nil
+}
+
+// Render returns the tile z/x/y cut from the map image.
+// When zoomed out, each tile pixel is the average of the map pixels
+// that it covers. When zoomed in, the map pixels are interpolated.
+// The map wraps east to west.
+func Render(src image.Image, z, x, y int) *image.RGBA {
+	b := src.Bounds()
+	cols, rows := Grid(z)
+	// map pixels per tile pixel
+	sx := float64(b.Dx()) / float64(cols*Size)
+	sy := float64(b.Dy()) / float64(rows*Size)
+	return resample(src, float64(x*Size)*sx, float64(y*Size)*sy, sx, sy, Size, Size)
+}
+
+// Resize returns the whole map image scaled to width by height pixels,
+// for thumbnails.
+func Resize(src image.Image, width, height int) *image.RGBA {
+	b := src.Bounds()
+	return resample(src, 0, 0, float64(b.Dx())/float64(width), float64(b.Dy())/float64(height), width, height)
+}
+
+// resample returns a width by height image that starts at x0, y0 in the
+// map image, with each pixel covering sx by sy map pixels.
+func resample(src image.Image, x0, y0, sx, sy float64, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for py := 0; py < height; py++ {
+		y := y0 + float64(py)*sy
+		for px := 0; px < width; px++ {
+			x := x0 + float64(px)*sx
+			var c color.RGBA
+			if sx > 1 {
+				c = average(src, x, y, sx, sy)
+			} else {
+				c = bilinear(src, x+sx/2-0.5, y+sy/2-0.5)
+			}
+			dst.SetRGBA(px, py, c)
+		}
+	}
+	return dst
+}
+
+// average returns the average color of the map pixels in the w x h box at x0, y0.
+func average(src image.Image, x0, y0, w, h float64) color.RGBA {
+	b := src.Bounds()
+	var r, g, bl, a, n float64
+	for y := int(y0); y < int(math.Ceil(y0+h)) && y < b.Dy(); y++ {
+		for x := int(x0); x < int(math.Ceil(x0+w)); x++ {
+			cr, cg, cb, ca := src.At(b.Min.X+x%b.Dx(), b.Min.Y+y).RGBA()
+			r, g, bl, a, n = r+float64(cr), g+float64(cg), bl+float64(cb), a+float64(ca), n+1
+		}
+	}
+	if n == 0 {
+		return color.RGBA{}
+	}
+	return color.RGBA{R: uint8(r / n / 257), G: uint8(g / n / 257), B: uint8(bl / n / 257), A: uint8(a / n / 257)}
+}
+
+// bilinear returns the color at x, y interpolated between the four nearest
+// map pixels, wrapping in x and clamping in y.
+func bilinear(src image.Image, x, y float64) color.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	fx, fy := math.Floor(x), math.Floor(y)
+	tx, ty := x-fx, y-fy
+	x0 := ((int(fx) % w) + w) % w
+	x1 := (x0 + 1) % w
+	y0 := clamp(int(fy), 0, h-1)
+	y1 := clamp(int(fy)+1, 0, h-1)
+
+	var out [4]float64
+	for _, p := range []struct {
+		x, y   int
+		weight float64
+	}{
+		{x0, y0, (1 - tx) * (1 - ty)},
+		{x1, y0, tx * (1 - ty)},
+		{x0, y1, (1 - tx) * ty},
+		{x1, y1, tx * ty},
+	} {
+		r, g, bl, a := src.At(b.Min.X+p.x, b.Min.Y+p.y).RGBA()
+		out[0] += float64(r) * p.weight
+		out[1] += float64(g) * p.weight
+		out[2] += float64(bl) * p.weight
+		out[3] += float64(a) * p.weight
+	}
+	return color.RGBA{R: uint8(out[0] / 257), G: uint8(out[1] / 257), B: uint8(out[2] / 257), A: uint8(out[3] / 257)}
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	} else if v > hi {
+		return hi
+	}
+	return v
+}
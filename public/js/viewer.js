@@ -0,0 +1,133 @@
+// worldgen - fractured terrain generator
+// Copyright (c) 2023 Michael D Henderson
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// TileViewer is a small slippy map for the tiles served by /tiles.
+// Zoom level z is 2^(z+1) tiles across and 2^z tiles down, like EPSG:4326
+// in Leaflet. Drag to pan, use the wheel to zoom. The map wraps east to west.
+class TileViewer {
+    constructor(el, options) {
+        this.el = el;
+        this.url = options.url;
+        this.maxZoom = options.maxZoom || 0;
+        this.query = "";
+        this.size = 256;
+        this.zoom = 0;
+        this.tiles = new Map(); // key to img
+        // the center of the view in map pixels at the current zoom
+        this.cx = this.size;
+        this.cy = this.size / 2;
+
+        el.addEventListener("pointerdown", e => this.startDrag(e));
+        el.addEventListener("wheel", e => {
+            e.preventDefault();
+            const r = el.getBoundingClientRect();
+            this.zoomBy(e.deltaY < 0 ? 1 : -1, e.clientX - r.left, e.clientY - r.top);
+        }, {passive: false});
+        window.addEventListener("resize", () => this.draw());
+        this.draw();
+    }
+
+    // setQuery changes the query string added to every tile url.
+    setQuery(query) {
+        this.query = query;
+        this.clear();
+        this.draw();
+    }
+
+    // zoomBy zooms in or out, keeping the point at px, py in the view still.
+    zoomBy(dz, px, py) {
+        const z = Math.min(this.maxZoom, Math.max(0, this.zoom + dz));
+        if (z === this.zoom) {
+            return;
+        }
+        if (px === undefined) {
+            px = this.el.clientWidth / 2;
+            py = this.el.clientHeight / 2;
+        }
+        const scale = Math.pow(2, z - this.zoom);
+        const mx = this.cx + px - this.el.clientWidth / 2;
+        const my = this.cy + py - this.el.clientHeight / 2;
+        this.cx = mx * scale - (px - this.el.clientWidth / 2);
+        this.cy = my * scale - (py - this.el.clientHeight / 2);
+        this.zoom = z;
+        this.clear();
+        this.draw();
+    }
+
+    startDrag(e) {
+        const x0 = e.clientX, y0 = e.clientY, cx = this.cx, cy = this.cy;
+        this.el.setPointerCapture(e.pointerId);
+        this.el.style.cursor = "grabbing";
+        const move = e => {
+            this.cx = cx - (e.clientX - x0);
+            this.cy = cy - (e.clientY - y0);
+            this.draw();
+        };
+        const up = () => {
+            this.el.style.cursor = "grab";
+            this.el.removeEventListener("pointermove", move);
+            this.el.removeEventListener("pointerup", up);
+        };
+        this.el.addEventListener("pointermove", move);
+        this.el.addEventListener("pointerup", up);
+    }
+
+    clear() {
+        for (const img of this.tiles.values()) {
+            img.remove();
+        }
+        this.tiles.clear();
+    }
+
+    // draw places the tiles that cover the view and removes the rest.
+    draw() {
+        const cols = 2 << this.zoom, rows = 1 << this.zoom;
+        const width = cols * this.size, height = rows * this.size;
+        const vw = this.el.clientWidth, vh = this.el.clientHeight;
+
+        // keep the poles from leaving the view, and wrap east to west
+        this.cy = height <= vh ? height / 2 : Math.min(height - vh / 2, Math.max(vh / 2, this.cy));
+        this.cx = ((this.cx % width) + width) % width;
+
+        const left = this.cx - vw / 2, top = this.cy - vh / 2;
+        const wanted = new Set();
+        for (let ty = Math.max(0, Math.floor(top / this.size)); ty < rows && ty * this.size < top + vh; ty++) {
+            for (let tx = Math.floor(left / this.size); tx * this.size < left + vw; tx++) {
+                const key = `${tx}/${ty}`;
+                wanted.add(key);
+                let img = this.tiles.get(key);
+                if (!img) {
+                    const x = ((tx % cols) + cols) % cols;
+                    img = document.createElement("img");
+                    img.draggable = false;
+                    img.style.position = "absolute";
+                    img.width = img.height = this.size;
+                    img.src = this.url.replace("{z}", this.zoom).replace("{x}", x).replace("{y}", ty) + (this.query ? "?" + this.query : "");
+                    this.el.appendChild(img);
+                    this.tiles.set(key, img);
+                }
+                img.style.left = `${tx * this.size - left}px`;
+                img.style.top = `${ty * this.size - top}px`;
+            }
+        }
+        for (const [key, img] of this.tiles) {
+            if (!wanted.has(key)) {
+                img.remove();
+                this.tiles.delete(key);
+            }
+        }
+    }
+}